@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// kubernetesCredentialsProvider reads the credentials from secrets of the namespace of the service
+const kubernetesCredentialsProvider = "kubernetes"
+
+// directoryCredentialsProvider reads the credentials from files, e.g. secrets mounted as volumes
+const directoryCredentialsProvider = "directory"
+
+// envCredentialsProvider reads the credentials from environment variables
+const envCredentialsProvider = "env"
+
+// credentialsProvider returns the content of a credentials secret, i.e. the YAML document with the URL and the
+// credentials of the prometheus instances
+type credentialsProvider interface {
+	GetCredentials(name string) ([]byte, error)
+}
+
+// newCredentialsProvider creates the provider configured with CREDENTIALS_PROVIDER. The kubernetes provider runs until
+// stopCh is closed
+func newCredentialsProvider(env envConfig, stopCh <-chan struct{}) (credentialsProvider, error) {
+	switch env.CredentialsProvider {
+	case kubernetesCredentialsProvider:
+		kubeClient, secretNamespace, err := newKubernetesClient(namespace)
+		if err != nil {
+			return nil, errors.New("could not create Kubernetes client: " + err.Error())
+		}
+		secrets, err := newSecretCache(kubeClient, secretNamespace, stopCh)
+		if err != nil {
+			return nil, err
+		}
+		return secrets, nil
+	case directoryCredentialsProvider:
+		if env.CredentialsDirectory == "" {
+			return nil, errors.New("CREDENTIALS_DIRECTORY is required for the " + directoryCredentialsProvider + " credentials provider")
+		}
+		return &directoryProvider{directory: env.CredentialsDirectory}, nil
+	case envCredentialsProvider:
+		return &envProvider{}, nil
+	}
+	return nil, errors.New("unknown credentials provider " + env.CredentialsProvider + " (expected " + kubernetesCredentialsProvider + ", " + directoryCredentialsProvider + " or " + envCredentialsProvider + ")")
+}
+
+// directoryProvider reads a secret from the file <directory>/<name>, or from <directory>/<name>/prometheus-credentials
+// if a whole secret has been mounted. The files are read for every evaluation, so updated files are picked up
+type directoryProvider struct {
+	directory string
+}
+
+func (p *directoryProvider) GetCredentials(name string) ([]byte, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, errors.New("invalid credentials file name " + name)
+	}
+
+	path := filepath.Join(p.directory, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		path = filepath.Join(path, credentialsSecretKey)
+	}
+	return ioutil.ReadFile(path)
+}
+
+var envVariableRegex = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// envProvider reads a secret from the environment variable named like the secret in upper case, with every character
+// other than letters and digits replaced by _, e.g. PROMETHEUS_CREDENTIALS_SOCKSHOP
+type envProvider struct{}
+
+func (p *envProvider) GetCredentials(name string) ([]byte, error) {
+	variable := getCredentialsEnvVariable(name)
+	value, ok := os.LookupEnv(variable)
+	if !ok {
+		return nil, errors.New("environment variable " + variable + " is not set")
+	}
+	return []byte(value), nil
+}
+
+func getCredentialsEnvVariable(name string) string {
+	return envVariableRegex.ReplaceAllString(strings.ToUpper(name), "_")
+}
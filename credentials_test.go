@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCredentialsProvider(t *testing.T) {
+	provider, err := newCredentialsProvider(envConfig{CredentialsProvider: directoryCredentialsProvider, CredentialsDirectory: "/etc/prometheus-credentials"}, nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, &directoryProvider{directory: "/etc/prometheus-credentials"}, provider)
+
+	provider, err = newCredentialsProvider(envConfig{CredentialsProvider: envCredentialsProvider}, nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, &envProvider{}, provider)
+
+	_, err = newCredentialsProvider(envConfig{CredentialsProvider: directoryCredentialsProvider}, nil)
+	assert.EqualError(t, err, "CREDENTIALS_DIRECTORY is required for the directory credentials provider")
+
+	_, err = newCredentialsProvider(envConfig{CredentialsProvider: "vault"}, nil)
+	assert.EqualError(t, err, "unknown credentials provider vault (expected kubernetes, directory or env)")
+}
+
+func TestDirectoryProvider(t *testing.T) {
+	directory, err := ioutil.TempDir("", "prometheus-credentials")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	// a single file per secret, and a secret mounted as directory
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(directory, "prometheus-credentials-sockshop"), []byte("url: https://prometheus.example.com"), 0600))
+	assert.Nil(t, os.Mkdir(filepath.Join(directory, "prometheus-credentials-podtato"), 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(directory, "prometheus-credentials-podtato", "prometheus-credentials"), []byte("url: https://podtato.example.com"), 0600))
+
+	provider := &directoryProvider{directory: directory}
+
+	credentials, err := provider.GetCredentials("prometheus-credentials-sockshop")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "url: https://prometheus.example.com", string(credentials))
+
+	credentials, err = provider.GetCredentials("prometheus-credentials-podtato")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "url: https://podtato.example.com", string(credentials))
+
+	_, err = provider.GetCredentials("prometheus-credentials-carts")
+	assert.True(t, os.IsNotExist(err))
+
+	// names must not leave the directory
+	for _, name := range []string{"", ".", "..", "../prometheus-credentials-sockshop", "sockshop/carts"} {
+		_, err = provider.GetCredentials(name)
+		assert.EqualError(t, err, "invalid credentials file name "+name)
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	os.Setenv("PROMETHEUS_CREDENTIALS_SOCKSHOP_DEV", "url: https://prometheus.example.com")
+	defer os.Unsetenv("PROMETHEUS_CREDENTIALS_SOCKSHOP_DEV")
+
+	provider := &envProvider{}
+
+	credentials, err := provider.GetCredentials("prometheus-credentials-sockshop-dev")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "url: https://prometheus.example.com", string(credentials))
+
+	_, err = provider.GetCredentials("prometheus-credentials-carts")
+	assert.EqualError(t, err, "environment variable PROMETHEUS_CREDENTIALS_CARTS is not set")
+}
+
+func TestGetCredentialsEnvVariable(t *testing.T) {
+	assert.EqualValues(t, "PROMETHEUS_CREDENTIALS_SOCKSHOP", getCredentialsEnvVariable("prometheus-credentials-sockshop"))
+	assert.EqualValues(t, "PROMETHEUS_CREDENTIALS_SOCK_SHOP_DEV_1", getCredentialsEnvVariable("prometheus-credentials-sock.shop-dev-1"))
+}
@@ -0,0 +1,95 @@
+package prometheus
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const AggregationAvg = "avg"
+const AggregationMin = "min"
+const AggregationMax = "max"
+const AggregationLast = "last"
+
+// percentile aggregations are given as p<percentile>, e.g. p95 or p99.9
+const aggregationPercentilePrefix = "p"
+
+func validateAggregation(aggregation string) error {
+	switch aggregation {
+	case AggregationAvg, AggregationMin, AggregationMax, AggregationLast:
+		return nil
+	}
+	_, err := parsePercentile(aggregation)
+	return err
+}
+
+func parsePercentile(aggregation string) (float64, error) {
+	if !strings.HasPrefix(aggregation, aggregationPercentilePrefix) {
+		return 0, errors.New("unsupported aggregation " + aggregation + " (expected avg, min, max, last or p<percentile>)")
+	}
+	percentile, err := strconv.ParseFloat(strings.TrimPrefix(aggregation, aggregationPercentilePrefix), 64)
+	if err != nil || math.IsNaN(percentile) || math.IsInf(percentile, 0) || percentile < 0 || percentile > 100 {
+		return 0, errors.New("invalid percentile " + aggregation + " (expected a value between p0 and p100)")
+	}
+	return percentile, nil
+}
+
+// aggregate reduces the samples of a series to a single value. NaN samples (e.g. a histogram_quantile without
+// observations) are ignored; if no other samples remain, NaN is returned
+func aggregate(samples []float64, aggregation string) (float64, error) {
+	values := []float64{}
+	for _, sample := range samples {
+		if !math.IsNaN(sample) {
+			values = append(values, sample)
+		}
+	}
+	if len(values) == 0 {
+		return math.NaN(), nil
+	}
+
+	switch aggregation {
+	case AggregationAvg:
+		sum := 0.0
+		for _, value := range values {
+			sum += value
+		}
+		return sum / float64(len(values)), nil
+	case AggregationMin:
+		min := values[0]
+		for _, value := range values {
+			min = math.Min(min, value)
+		}
+		return min, nil
+	case AggregationMax:
+		max := values[0]
+		for _, value := range values {
+			max = math.Max(max, value)
+		}
+		return max, nil
+	case AggregationLast:
+		return values[len(values)-1], nil
+	}
+
+	percentile, err := parsePercentile(aggregation)
+	if err != nil {
+		return 0, err
+	}
+	return calculatePercentile(values, percentile), nil
+}
+
+// calculatePercentile linearly interpolates between the two closest ranks
+func calculatePercentile(values []float64, percentile float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	rank := percentile / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
@@ -0,0 +1,47 @@
+package prometheus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	samples := []float64{4, 1, math.NaN(), 3, 2, 5}
+
+	tests := map[string]float64{
+		AggregationAvg:  3,
+		AggregationMin:  1,
+		AggregationMax:  5,
+		AggregationLast: 5,
+		"p50":           3,
+		"p100":          5,
+		"p0":            1,
+		"p90":           4.6,
+	}
+	for aggregation, want := range tests {
+		value, err := aggregate(samples, aggregation)
+		assert.Nil(t, err, aggregation)
+		assert.InDelta(t, want, value, 0.000001, aggregation)
+	}
+}
+
+func TestAggregateWithoutSamples(t *testing.T) {
+	value, err := aggregate([]float64{math.NaN()}, AggregationMax)
+
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(value))
+}
+
+func TestAggregateWithInvalidAggregation(t *testing.T) {
+	_, err := aggregate([]float64{1}, "median")
+
+	assert.NotNil(t, err)
+}
+
+func TestAggregateWithNonFinitePercentile(t *testing.T) {
+	for _, aggregation := range []string{"pNaN", "pInf", "p+Inf", "p-Inf"} {
+		_, err := aggregate([]float64{1, 2}, aggregation)
+		assert.EqualError(t, err, "invalid percentile "+aggregation+" (expected a value between p0 and p100)")
+	}
+}
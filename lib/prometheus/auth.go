@@ -0,0 +1,66 @@
+package prometheus
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// tenantHeader selects the tenant of multi-tenant Prometheus implementations like Cortex, Mimir and Thanos
+const tenantHeader = "X-Scope-OrgID"
+
+// setRequestHeaders adds the custom headers, the tenant and the authentication of the handler to a request. A bearer
+// token takes precedence over OAuth2, which takes precedence over basic authentication
+func (ph *Handler) setRequestHeaders(req *http.Request) error {
+	for name, value := range ph.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if ph.TenantID != "" {
+		tenantID, err := ph.renderTenantID()
+		if err != nil {
+			return err
+		}
+		if tenantID != "" {
+			req.Header.Set(tenantHeader, tenantID)
+		}
+	}
+
+	if ph.Username != "" && ph.Password != "" {
+		req.SetBasicAuth(ph.Username, ph.Password)
+	}
+
+	if ph.OAuth2 != nil {
+		token, err := ph.getOAuth2Token(req.Context())
+		if err != nil {
+			return err
+		}
+		token.SetAuthHeader(req)
+	}
+
+	token, err := ph.getBearerToken()
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// getBearerToken returns the configured token, or reads it from the token file. The file is read for each request, so
+// a rotated token is picked up without restarting the service
+func (ph *Handler) getBearerToken() (string, error) {
+	if ph.BearerToken != "" {
+		return ph.BearerToken, nil
+	}
+	if ph.BearerTokenFile == "" {
+		return "", nil
+	}
+	token, err := ioutil.ReadFile(ph.BearerTokenFile)
+	if err != nil {
+		return "", errors.New("could not read bearer token file: " + err.Error())
+	}
+	return strings.TrimSpace(string(token)), nil
+}
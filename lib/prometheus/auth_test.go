@@ -0,0 +1,116 @@
+package prometheus
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetRequestHeadersWithBearerToken(t *testing.T) {
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.BearerToken = "my-token"
+	ph.Headers = map[string]string{"X-Api-Key": "my-key"}
+
+	req, _ := http.NewRequest("GET", "http://prometheus/api/v1/query", nil)
+	err := ph.setRequestHeaders(req)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, "Bearer my-token", req.Header.Get("Authorization"))
+	assert.EqualValues(t, "my-key", req.Header.Get("X-Api-Key"))
+}
+
+func TestSetRequestHeadersWithBearerTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prometheus-sli-service")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.BearerTokenFile = tokenFile
+
+	req, _ := http.NewRequest("GET", "http://prometheus/api/v1/query", nil)
+	err = ph.setRequestHeaders(req)
+	assert.EqualError(t, err, "could not read bearer token file: open "+tokenFile+": no such file or directory")
+
+	// the token is read again for each request
+	for _, token := range []string{"first-token", "rotated-token"} {
+		assert.Nil(t, ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600))
+		req, _ := http.NewRequest("GET", "http://prometheus/api/v1/query", nil)
+		assert.Nil(t, ph.setRequestHeaders(req))
+		assert.EqualValues(t, "Bearer "+token, req.Header.Get("Authorization"))
+	}
+}
+
+func TestSetRequestHeadersWithoutAuthentication(t *testing.T) {
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+
+	req, _ := http.NewRequest("GET", "http://prometheus/api/v1/query", nil)
+	err := ph.setRequestHeaders(req)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, "", req.Header.Get("Authorization"))
+}
+
+func TestGetSLIValueSendsAuthenticationHeaders(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer my-token" || r.Header.Get("X-Api-Key") != "my-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1571649085,"1"]}}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.BearerToken = "my-token"
+	ph.Headers = map[string]string{"X-Api-Key": "my-key"}
+
+	logger := keptncommon.NewLogger("", "", "")
+	value, err := ph.GetSLIValue(context.Background(), Throughput, "1571649084", "1571649085", logger)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1.0, value)
+}
+
+func TestSetRequestHeadersWithTenantID(t *testing.T) {
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.TenantID = "$PROJECT-$STAGE"
+
+	req, _ := http.NewRequest("GET", "http://prometheus/api/v1/query", nil)
+	err := ph.setRequestHeaders(req)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, "sockshop-dev", req.Header.Get("X-Scope-OrgID"))
+
+	ph.TenantID = "{{ .Unknown }"
+	err = ph.setRequestHeaders(req)
+	assert.NotNil(t, err)
+}
+
+func TestSetRequestHeadersWithBasicAuthentication(t *testing.T) {
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.Username = "user"
+	ph.Password = "password"
+
+	req, _ := http.NewRequest("GET", "http://prometheus/api/v1/query", nil)
+	assert.Nil(t, ph.setRequestHeaders(req))
+	username, password, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.EqualValues(t, "user", username)
+	assert.EqualValues(t, "password", password)
+
+	// a bearer token takes precedence
+	ph.BearerToken = "my-token"
+	req, _ = http.NewRequest("GET", "http://prometheus/api/v1/query", nil)
+	assert.Nil(t, ph.setRequestHeaders(req))
+	assert.EqualValues(t, "Bearer my-token", req.Header.Get("Authorization"))
+}
@@ -0,0 +1,186 @@
+package prometheus
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// TLSConfig defines how the connection to a Prometheus instance is secured. Certificates and keys are given either as
+// PEM (ca, cert, key) or as path of a PEM file (ca_file, cert_file, key_file)
+type TLSConfig struct {
+	CA                 string `json:"ca" yaml:"ca"`
+	CAFile             string `json:"ca_file" yaml:"ca_file"`
+	Cert               string `json:"cert" yaml:"cert"`
+	CertFile           string `json:"cert_file" yaml:"cert_file"`
+	Key                string `json:"key" yaml:"key"`
+	KeyFile            string `json:"key_file" yaml:"key_file"`
+	ServerName         string `json:"server_name" yaml:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+}
+
+// ProxyConfig defines the proxy used to connect to a Prometheus instance. The proxy is either an HTTP proxy
+// (http://proxy:3128) or a SOCKS5 proxy (socks5://proxy:1080). NoProxy is a comma-separated list of hosts, domains
+// (.example.com) and CIDRs that are connected directly
+type ProxyConfig struct {
+	URL     string
+	NoProxy string
+}
+
+// httpClientIdleTimeout is the time after which a cached client that has not been requested again is removed and its
+// idle connections are closed, e.g. after the certificate or the secret of the client has been rotated
+const httpClientIdleTimeout = time.Hour
+
+type cachedHTTPClient struct {
+	client   *http.Client
+	lastUsed time.Time
+}
+
+// httpClients caches the clients of all TLS and proxy configurations that are in use, so each configuration has a
+// single transport and connection pool
+var httpClients = map[string]*cachedHTTPClient{}
+var httpClientsMutex sync.Mutex
+
+// NewHTTPClient returns an HTTP client for a Prometheus instance. Without TLS configuration, the certificate of the
+// instance is verified against the system certificates. Without proxy configuration, the proxy is taken from the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables. Clients are shared by all handlers with the same
+// configuration, so idle connections are reused across events
+func NewHTTPClient(tlsConfig *TLSConfig, proxyConfig *ProxyConfig) (*http.Client, error) {
+	key := httpClientCacheKey(tlsConfig, proxyConfig)
+
+	httpClientsMutex.Lock()
+	defer httpClientsMutex.Unlock()
+	now := time.Now()
+	removeIdleHTTPClients(now)
+	if cached, ok := httpClients[key]; ok {
+		cached.lastUsed = now
+		return cached.client, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyConfig != nil && proxyConfig.URL != "" {
+		proxy, err := proxyConfig.build()
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = proxy
+	}
+	if tlsConfig != nil {
+		config, err := tlsConfig.build()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	}
+	client := &http.Client{Transport: transport}
+	httpClients[key] = &cachedHTTPClient{client: client, lastUsed: now}
+	return client, nil
+}
+
+// removeIdleHTTPClients removes the clients that have not been requested within httpClientIdleTimeout. Handlers that
+// still use such a client can continue to use it
+func removeIdleHTTPClients(now time.Time) {
+	for key, cached := range httpClients {
+		if now.Sub(cached.lastUsed) > httpClientIdleTimeout {
+			delete(httpClients, key)
+			cached.client.CloseIdleConnections()
+		}
+	}
+}
+
+// httpClientCacheKey identifies a TLS and proxy configuration by a hash, so keys and passwords are not kept in the
+// cache. Certificate files are part of the key with their content, so a rotated certificate results in a new client
+func httpClientCacheKey(tlsConfig *TLSConfig, proxyConfig *ProxyConfig) string {
+	parts := []string{}
+	if tlsConfig != nil {
+		parts = append(parts, tlsConfig.CA, tlsConfig.Cert, tlsConfig.Key, tlsConfig.ServerName, strconv.FormatBool(tlsConfig.InsecureSkipVerify))
+		for _, file := range []string{tlsConfig.CAFile, tlsConfig.CertFile, tlsConfig.KeyFile} {
+			content, _ := ioutil.ReadFile(file)
+			parts = append(parts, file, string(content))
+		}
+	}
+	if proxyConfig != nil {
+		parts = append(parts, proxyConfig.URL, proxyConfig.NoProxy)
+	}
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+func (c *ProxyConfig) build() (func(*http.Request) (*url.URL, error), error) {
+	proxyURL, err := url.Parse(c.URL)
+	if err != nil || proxyURL.Host == "" {
+		return nil, errors.New("invalid proxy URL " + RedactURLCredentials(c.URL))
+	}
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5" {
+		return nil, errors.New("unsupported proxy scheme " + proxyURL.Scheme + " (expected http, https or socks5)")
+	}
+
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  c.URL,
+		HTTPSProxy: c.URL,
+		NoProxy:    c.NoProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, nil
+}
+
+func (c *TLSConfig) build() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	ca, err := readPEM(c.CA, c.CAFile)
+	if err != nil {
+		return nil, errors.New("could not read CA certificate: " + err.Error())
+	}
+	if ca != nil {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("could not parse CA certificate: no PEM encoded certificate found")
+		}
+	}
+
+	cert, err := readPEM(c.Cert, c.CertFile)
+	if err != nil {
+		return nil, errors.New("could not read client certificate: " + err.Error())
+	}
+	key, err := readPEM(c.Key, c.KeyFile)
+	if err != nil {
+		return nil, errors.New("could not read client key: " + err.Error())
+	}
+	if (cert == nil) != (key == nil) {
+		return nil, errors.New("client certificate and client key have to be configured together")
+	}
+	if cert != nil {
+		keyPair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, errors.New("could not parse client certificate: " + err.Error())
+		}
+		config.Certificates = []tls.Certificate{keyPair}
+	}
+	return config, nil
+}
+
+// readPEM returns the inline PEM, or the content of the file if no inline PEM is given
+func readPEM(inline string, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+	return nil, nil
+}
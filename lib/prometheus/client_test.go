@@ -0,0 +1,211 @@
+package prometheus
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// generateTestCertificate returns a self-signed certificate and its key as PEM
+func generateTestCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "prometheus-sli-service"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+}
+
+func getServerCA(server *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+}
+
+func TestNewHTTPClientVerifiesCertificates(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(nil, nil)
+	assert.Nil(t, err)
+	_, err = client.Get(server.URL)
+	assert.NotNil(t, err)
+
+	client, err = NewHTTPClient(&TLSConfig{CA: getServerCA(server)}, nil)
+	assert.Nil(t, err)
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+
+	client, err = NewHTTPClient(&TLSConfig{InsecureSkipVerify: true}, nil)
+	assert.Nil(t, err)
+	resp, err = client.Get(server.URL)
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestNewHTTPClientWithServerName(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// the certificate of the test server is valid for example.com
+	client, err := NewHTTPClient(&TLSConfig{CA: getServerCA(server), ServerName: "example.com"}, nil)
+	assert.Nil(t, err)
+	_, err = client.Get(server.URL)
+	assert.Nil(t, err)
+
+	client, err = NewHTTPClient(&TLSConfig{CA: getServerCA(server), ServerName: "prometheus.example.org"}, nil)
+	assert.Nil(t, err)
+	_, err = client.Get(server.URL)
+	assert.NotNil(t, err)
+}
+
+func TestNewHTTPClientWithClientCertificate(t *testing.T) {
+	cert, key := generateTestCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM([]byte(cert))
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	client, err := NewHTTPClient(&TLSConfig{CA: getServerCA(server)}, nil)
+	assert.Nil(t, err)
+	_, err = client.Get(server.URL)
+	assert.NotNil(t, err)
+
+	client, err = NewHTTPClient(&TLSConfig{CA: getServerCA(server), Cert: cert, Key: key}, nil)
+	assert.Nil(t, err)
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestNewHTTPClientWithInvalidTLSConfig(t *testing.T) {
+	cert, _ := generateTestCertificate(t)
+
+	_, err := NewHTTPClient(&TLSConfig{CA: "no certificate"}, nil)
+	assert.EqualError(t, err, "could not parse CA certificate: no PEM encoded certificate found")
+
+	_, err = NewHTTPClient(&TLSConfig{Cert: cert}, nil)
+	assert.EqualError(t, err, "client certificate and client key have to be configured together")
+
+	_, err = NewHTTPClient(&TLSConfig{CAFile: "/does/not/exist.pem"}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestNewHTTPClientWithProxy(t *testing.T) {
+	proxiedHosts := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// an HTTP proxy receives the absolute URL of the target
+		proxiedHosts = append(proxiedHosts, r.URL.Host)
+		w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1571649085,"1"]}}`))
+	}))
+	defer proxy.Close()
+
+	client, err := NewHTTPClient(nil, &ProxyConfig{URL: proxy.URL, NoProxy: "direct.prometheus.invalid,.internal.invalid"})
+	assert.Nil(t, err)
+
+	resp, err := client.Get("http://prometheus.example.com:9090/api/v1/query?query=up")
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	assert.EqualValues(t, []string{"prometheus.example.com:9090"}, proxiedHosts)
+
+	// hosts in the no proxy list are connected directly, which fails for these hosts
+	for _, host := range []string{"direct.prometheus.invalid", "prometheus.internal.invalid"} {
+		_, err = client.Get("http://" + host + "/api/v1/query?query=up")
+		assert.NotNil(t, err, host)
+	}
+	assert.EqualValues(t, 1, len(proxiedHosts))
+}
+
+func TestNewHTTPClientIsReused(t *testing.T) {
+	client, err := NewHTTPClient(&TLSConfig{ServerName: "prometheus.example.com"}, &ProxyConfig{URL: "http://proxy.example.com:3128"})
+	assert.Nil(t, err)
+	sameClient, err := NewHTTPClient(&TLSConfig{ServerName: "prometheus.example.com"}, &ProxyConfig{URL: "http://proxy.example.com:3128"})
+	assert.Nil(t, err)
+	assert.True(t, client == sameClient)
+
+	otherClient, err := NewHTTPClient(&TLSConfig{ServerName: "prometheus.example.com"}, &ProxyConfig{URL: "http://proxy.example.com:8080"})
+	assert.Nil(t, err)
+	assert.False(t, client == otherClient)
+
+	// a rotated certificate file results in a new client
+	dir, err := ioutil.TempDir("", "prometheus-tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca, _ := generateTestCertificate(t)
+	assert.Nil(t, ioutil.WriteFile(caFile, []byte(ca), 0600))
+	client, err = NewHTTPClient(&TLSConfig{CAFile: caFile}, nil)
+	assert.Nil(t, err)
+	rotatedCA, _ := generateTestCertificate(t)
+	assert.Nil(t, ioutil.WriteFile(caFile, []byte(rotatedCA), 0600))
+	rotatedClient, err := NewHTTPClient(&TLSConfig{CAFile: caFile}, nil)
+	assert.Nil(t, err)
+	assert.False(t, client == rotatedClient)
+}
+
+func TestNewHTTPClientRemovesIdleClients(t *testing.T) {
+	cert, key := generateTestCertificate(t)
+	tlsConfig := &TLSConfig{Cert: cert, Key: key}
+	client, err := NewHTTPClient(tlsConfig, nil)
+	assert.Nil(t, err)
+
+	// the cache does not contain the private key
+	httpClientsMutex.Lock()
+	cacheKey := httpClientCacheKey(tlsConfig, nil)
+	assert.NotContains(t, cacheKey, "PRIVATE KEY")
+	httpClients[cacheKey].lastUsed = time.Now().Add(-2 * httpClientIdleTimeout)
+	httpClientsMutex.Unlock()
+
+	// a client that has not been requested for a while is replaced
+	newClient, err := NewHTTPClient(tlsConfig, nil)
+	assert.Nil(t, err)
+	assert.False(t, client == newClient)
+}
+
+func TestProxyConfig(t *testing.T) {
+	proxy, err := (&ProxyConfig{URL: "socks5://proxy.example.com:1080", NoProxy: "10.0.0.0/8"}).build()
+	assert.Nil(t, err)
+
+	req, _ := http.NewRequest("GET", "https://prometheus.example.com/api/v1/query", nil)
+	proxyURL, err := proxy(req)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "socks5://proxy.example.com:1080", proxyURL.String())
+
+	req, _ = http.NewRequest("GET", "https://10.1.2.3/api/v1/query", nil)
+	proxyURL, err = proxy(req)
+	assert.Nil(t, err)
+	assert.Nil(t, proxyURL)
+
+	_, err = (&ProxyConfig{URL: "ftp://proxy.example.com"}).build()
+	assert.EqualError(t, err, "unsupported proxy scheme ftp (expected http, https or socks5)")
+
+	_, err = (&ProxyConfig{URL: "http://user:secret@"}).build()
+	assert.EqualError(t, err, "invalid proxy URL http://<redacted>@")
+}
@@ -0,0 +1,75 @@
+package prometheus
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// EmptyResultFail fails the indicator if the query returned no data
+const EmptyResultFail = "fail"
+
+// EmptyResultZero reports 0 if the query returned no data
+const EmptyResultZero = "zero"
+
+// EmptyResultWarn reports 0 with a warning if the query returned no data
+const EmptyResultWarn = "warn"
+
+// EmptyResultDefault reports a configured value if the query returned no data, given as default:<value>
+const EmptyResultDefault = "default"
+
+// EmptyResultPolicy defines how an indicator is reported if its query returned no data, e.g. because of a misspelled
+// metric or a missing scrape job. The zero value reports 0, which is the behavior of previous versions
+type EmptyResultPolicy struct {
+	Action       string
+	DefaultValue float64
+}
+
+// ParseEmptyResultPolicy parses a policy given as fail, zero, warn or default:<value>
+func ParseEmptyResultPolicy(value string) (EmptyResultPolicy, error) {
+	value = strings.TrimSpace(value)
+	switch value {
+	case EmptyResultFail, EmptyResultZero, EmptyResultWarn:
+		return EmptyResultPolicy{Action: value}, nil
+	}
+
+	if strings.HasPrefix(value, EmptyResultDefault+":") {
+		defaultValue, err := strconv.ParseFloat(strings.TrimPrefix(value, EmptyResultDefault+":"), 64)
+		if err != nil {
+			return EmptyResultPolicy{}, errors.New("invalid default value in empty result policy " + value)
+		}
+		return EmptyResultPolicy{Action: EmptyResultDefault, DefaultValue: defaultValue}, nil
+	}
+	return EmptyResultPolicy{}, errors.New("unsupported empty result policy " + value + " (expected fail, zero, warn or default:<value>)")
+}
+
+func (p EmptyResultPolicy) String() string {
+	switch p.Action {
+	case "":
+		return EmptyResultZero
+	case EmptyResultDefault:
+		return EmptyResultDefault + ":" + strconv.FormatFloat(p.DefaultValue, 'f', -1, 64)
+	}
+	return p.Action
+}
+
+// apply returns the value and the message that are reported for a query without data
+func (p EmptyResultPolicy) apply() (float64, string, error) {
+	switch p.Action {
+	case EmptyResultFail:
+		return 0, "", errors.New("query returned no data (empty result policy: " + p.String() + ")")
+	case EmptyResultWarn:
+		return 0, "WARNING: query returned no data, reporting 0 (empty result policy: " + p.String() + ")", nil
+	case EmptyResultDefault:
+		return p.DefaultValue, "query returned no data, reporting default value " + strconv.FormatFloat(p.DefaultValue, 'f', -1, 64) + " (empty result policy: " + p.String() + ")", nil
+	}
+	return 0, "query returned no data, reporting 0 (empty result policy: " + p.String() + ")", nil
+}
+
+// getEmptyResultPolicy returns the policy of the indicator, or the policy of the handler if the indicator has none
+func (ph *Handler) getEmptyResultPolicy(options *indicatorOptions) EmptyResultPolicy {
+	if options.EmptyResultPolicy != nil {
+		return *options.EmptyResultPolicy
+	}
+	return ph.EmptyResultPolicy
+}
@@ -0,0 +1,60 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEmptyResultPolicy(t *testing.T) {
+	policy, err := ParseEmptyResultPolicy("fail")
+	assert.Nil(t, err)
+	assert.EqualValues(t, EmptyResultPolicy{Action: EmptyResultFail}, policy)
+
+	policy, err = ParseEmptyResultPolicy("default:1.5")
+	assert.Nil(t, err)
+	assert.EqualValues(t, EmptyResultPolicy{Action: EmptyResultDefault, DefaultValue: 1.5}, policy)
+	assert.EqualValues(t, "default:1.5", policy.String())
+
+	_, err = ParseEmptyResultPolicy("default:abc")
+	assert.EqualError(t, err, "invalid default value in empty result policy default:abc")
+
+	_, err = ParseEmptyResultPolicy("ignore")
+	assert.EqualError(t, err, "unsupported empty result policy ignore (expected fail, zero, warn or default:<value>)")
+}
+
+func TestApplyEmptyResultPolicy(t *testing.T) {
+	value, message, err := EmptyResultPolicy{}.apply()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0.0, value)
+	assert.EqualValues(t, "query returned no data, reporting 0 (empty result policy: zero)", message)
+
+	value, message, err = EmptyResultPolicy{Action: EmptyResultWarn}.apply()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0.0, value)
+	assert.EqualValues(t, "WARNING: query returned no data, reporting 0 (empty result policy: warn)", message)
+
+	value, message, err = EmptyResultPolicy{Action: EmptyResultDefault, DefaultValue: 100}.apply()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 100.0, value)
+	assert.EqualValues(t, "query returned no data, reporting default value 100 (empty result policy: default:100)", message)
+
+	_, _, err = EmptyResultPolicy{Action: EmptyResultFail}.apply()
+	assert.EqualError(t, err, "query returned no data (empty result policy: fail)")
+}
+
+func TestGetEmptyResultPolicy(t *testing.T) {
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.EmptyResultPolicy = EmptyResultPolicy{Action: EmptyResultFail}
+	ph.CustomQueries = map[string]string{
+		"error_rate": "EMPTY_RESULT=default:0;sum(rate(http_requests_total{status=~\"5..\"}[5m]))",
+	}
+
+	options, err := ph.getIndicatorOptions("error_rate")
+	assert.Nil(t, err)
+	assert.EqualValues(t, EmptyResultPolicy{Action: EmptyResultDefault}, ph.getEmptyResultPolicy(options))
+
+	options, err = ph.getIndicatorOptions("throughput")
+	assert.Nil(t, err)
+	assert.EqualValues(t, EmptyResultPolicy{Action: EmptyResultFail}, ph.getEmptyResultPolicy(options))
+}
@@ -0,0 +1,43 @@
+package prometheus
+
+import (
+	"errors"
+	"math"
+)
+
+const MergeSum = "sum"
+const MergeAvg = "avg"
+const MergeMax = "max"
+const MergeMin = "min"
+
+func validateMerge(merge string) error {
+	switch merge {
+	case MergeSum, MergeAvg, MergeMax, MergeMin:
+		return nil
+	}
+	return errors.New("unsupported merge " + merge + " (expected sum, avg, max or min)")
+}
+
+// MergeValues merges the values of an indicator queried from several datasources into one value. Unlike aggregations,
+// NaN values are not ignored: a datasource without value makes the merged value NaN, so that a sum or an average never
+// silently covers only some of the datasources
+func MergeValues(values []float64, merge string) (float64, error) {
+	if err := validateMerge(merge); err != nil {
+		return 0, err
+	}
+	for _, value := range values {
+		if math.IsNaN(value) {
+			return math.NaN(), nil
+		}
+	}
+	if merge != MergeSum {
+		// avg, max and min are aggregations of the same name
+		return aggregate(values, merge)
+	}
+
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum, nil
+}
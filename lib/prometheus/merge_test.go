@@ -0,0 +1,37 @@
+package prometheus
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeValues(t *testing.T) {
+	values := []float64{4, 1, 3}
+
+	tests := map[string]float64{
+		MergeSum: 8,
+		MergeAvg: 8.0 / 3,
+		MergeMax: 4,
+		MergeMin: 1,
+	}
+	for merge, want := range tests {
+		value, err := MergeValues(values, merge)
+		assert.Nil(t, err, merge)
+		assert.InDelta(t, want, value, 0.000001, merge)
+	}
+}
+
+func TestMergeValuesWithNaN(t *testing.T) {
+	for _, merge := range []string{MergeSum, MergeAvg, MergeMax, MergeMin} {
+		value, err := MergeValues([]float64{4, math.NaN(), 3}, merge)
+		assert.Nil(t, err, merge)
+		assert.True(t, math.IsNaN(value), merge)
+	}
+}
+
+func TestMergeValuesWithInvalidMerge(t *testing.T) {
+	_, err := MergeValues([]float64{1, 2}, "p95")
+	assert.EqualError(t, err, "unsupported merge p95 (expected sum, avg, max or min)")
+}
@@ -0,0 +1,115 @@
+package prometheus
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// OAuth2Config defines the client credentials used to fetch an access token for a Prometheus instance
+type OAuth2Config struct {
+	ClientID       string            `json:"client_id" yaml:"client_id"`
+	ClientSecret   string            `json:"client_secret" yaml:"client_secret"`
+	TokenURL       string            `json:"token_url" yaml:"token_url"`
+	Scopes         []string          `json:"scopes" yaml:"scopes"`
+	EndpointParams map[string]string `json:"endpoint_params" yaml:"endpoint_params"`
+}
+
+// cachedOAuth2Token is the access token of a client. The mutex is held while a token is fetched, so concurrent queries
+// of the same client wait for one token instead of each fetching their own
+type cachedOAuth2Token struct {
+	config      *clientcredentials.Config
+	tokenClient *http.Client
+	mutex       sync.Mutex
+	token       *oauth2.Token
+	// lastUsed is protected by oauth2TokensMutex
+	lastUsed time.Time
+}
+
+// oauth2Tokens caches the tokens of all clients and transports, so a token is reused across events until it expires.
+// Like HTTP clients, tokens that have not been used within httpClientIdleTimeout are removed
+var oauth2Tokens = map[string]*cachedOAuth2Token{}
+var oauth2TokensMutex sync.Mutex
+
+// getOAuth2Token returns a valid access token. A token is only fetched from the token URL if there is no cached token
+// or the cached token is about to expire. The token is fetched with the context of the query, so the query and
+// evaluation timeouts apply to the token request as well
+func (ph *Handler) getOAuth2Token(ctx context.Context) (*oauth2.Token, error) {
+	cached := ph.getCachedOAuth2Token()
+	cached.mutex.Lock()
+	defer cached.mutex.Unlock()
+	if cached.token.Valid() {
+		return cached.token, nil
+	}
+
+	token, err := cached.config.Token(context.WithValue(ctx, oauth2.HTTPClient, cached.tokenClient))
+	if err != nil {
+		return nil, errors.New("could not fetch OAuth2 token: " + err.Error())
+	}
+	cached.token = token
+	return token, nil
+}
+
+func (ph *Handler) getCachedOAuth2Token() *cachedOAuth2Token {
+	// the transport is part of the key, so a token is never fetched with the TLS and proxy settings of another handler
+	var transport http.RoundTripper
+	transportKey := "default transport"
+	if ph.HTTPClient != nil && ph.HTTPClient.Transport != nil {
+		transport = ph.HTTPClient.Transport
+		transportKey = fmt.Sprintf("transport %p", transport)
+	}
+	key := ph.OAuth2.cacheKey() + "\n" + transportKey
+
+	oauth2TokensMutex.Lock()
+	defer oauth2TokensMutex.Unlock()
+	now := time.Now()
+	for cachedKey, cached := range oauth2Tokens {
+		if now.Sub(cached.lastUsed) > httpClientIdleTimeout {
+			delete(oauth2Tokens, cachedKey)
+		}
+	}
+	if cached, ok := oauth2Tokens[key]; ok {
+		cached.lastUsed = now
+		return cached
+	}
+
+	config := &clientcredentials.Config{
+		ClientID:       ph.OAuth2.ClientID,
+		ClientSecret:   ph.OAuth2.ClientSecret,
+		TokenURL:       ph.OAuth2.TokenURL,
+		Scopes:         ph.OAuth2.Scopes,
+		EndpointParams: url.Values{},
+	}
+	for key, value := range ph.OAuth2.EndpointParams {
+		config.EndpointParams.Set(key, value)
+	}
+
+	// the token is fetched with the transport of the handler, so TLS and proxy settings apply to the token URL as well
+	cached := &cachedOAuth2Token{config: config, tokenClient: &http.Client{Transport: transport}, lastUsed: now}
+	oauth2Tokens[key] = cached
+	return cached
+}
+
+// cacheKey identifies the client. It is hashed, so the client secret is not kept in plain text in the cache
+func (c *OAuth2Config) cacheKey() string {
+	scopes := append([]string{}, c.Scopes...)
+	sort.Strings(scopes)
+	params := []string{}
+	for key, value := range c.EndpointParams {
+		params = append(params, key+"="+value)
+	}
+	sort.Strings(params)
+	hash := sha256.Sum256([]byte(strings.Join([]string{c.TokenURL, c.ClientID, c.ClientSecret, strings.Join(scopes, " "), strings.Join(params, "&")}, "\n")))
+	return hex.EncodeToString(hash[:])
+}
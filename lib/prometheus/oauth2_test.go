@@ -0,0 +1,171 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestTokenServer returns a token server that issues access-token-<n> for the n-th request of the client my-client
+func newTestTokenServer(t *testing.T, expiresIn string, tokenRequests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if clientID != "my-client" || clientSecret != "my-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		assert.Nil(t, r.ParseForm())
+		assert.EqualValues(t, "client_credentials", r.Form.Get("grant_type"))
+		assert.EqualValues(t, "metrics:read", r.Form.Get("scope"))
+
+		*tokenRequests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access-token-` + string(rune('0'+*tokenRequests)) + `","token_type":"Bearer","expires_in":` + expiresIn + `}`))
+	}))
+}
+
+func newTestOAuth2PrometheusServer(authorizations *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*authorizations = append(*authorizations, r.Header.Get("Authorization"))
+		w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1571649085,"1"]}}`))
+	}))
+}
+
+func TestGetSLIValueWithOAuth2(t *testing.T) {
+	tokenRequests := 0
+	tokenServer := newTestTokenServer(t, "3600", &tokenRequests)
+	defer tokenServer.Close()
+	authorizations := []string{}
+	prometheusServer := newTestOAuth2PrometheusServer(&authorizations)
+	defer prometheusServer.Close()
+
+	logger := keptncommon.NewLogger("", "", "")
+	for i := 0; i < 2; i++ {
+		ph := NewPrometheusHandler(prometheusServer.URL, "sockshop", "dev", "carts", nil)
+		ph.OAuth2 = &OAuth2Config{ClientID: "my-client", ClientSecret: "my-secret", TokenURL: tokenServer.URL, Scopes: []string{"metrics:read"}}
+		_, err := ph.GetSLIValue(context.Background(), Throughput, "1571649084", "1571649085", logger)
+		assert.Nil(t, err)
+	}
+
+	// the token is cached across handlers until it expires
+	assert.EqualValues(t, 1, tokenRequests)
+	assert.EqualValues(t, []string{"Bearer access-token-1", "Bearer access-token-1"}, authorizations)
+}
+
+func TestGetSLIValueWithExpiredOAuth2Token(t *testing.T) {
+	tokenRequests := 0
+	tokenServer := newTestTokenServer(t, "1", &tokenRequests)
+	defer tokenServer.Close()
+	authorizations := []string{}
+	prometheusServer := newTestOAuth2PrometheusServer(&authorizations)
+	defer prometheusServer.Close()
+
+	ph := NewPrometheusHandler(prometheusServer.URL, "sockshop", "dev", "carts", nil)
+	ph.OAuth2 = &OAuth2Config{ClientID: "my-client", ClientSecret: "my-secret", TokenURL: tokenServer.URL, Scopes: []string{"metrics:read"}}
+
+	logger := keptncommon.NewLogger("", "", "")
+	for i := 0; i < 2; i++ {
+		_, err := ph.GetSLIValue(context.Background(), Throughput, "1571649084", "1571649085", logger)
+		assert.Nil(t, err)
+	}
+
+	// a token that is about to expire is refreshed
+	assert.EqualValues(t, 2, tokenRequests)
+	assert.EqualValues(t, []string{"Bearer access-token-1", "Bearer access-token-2"}, authorizations)
+}
+
+func TestGetSLIValueWithInvalidOAuth2Client(t *testing.T) {
+	tokenRequests := 0
+	tokenServer := newTestTokenServer(t, "3600", &tokenRequests)
+	defer tokenServer.Close()
+	authorizations := []string{}
+	prometheusServer := newTestOAuth2PrometheusServer(&authorizations)
+	defer prometheusServer.Close()
+
+	ph := NewPrometheusHandler(prometheusServer.URL, "sockshop", "dev", "carts", nil)
+	ph.OAuth2 = &OAuth2Config{ClientID: "my-client", ClientSecret: "wrong-secret", TokenURL: tokenServer.URL}
+
+	logger := keptncommon.NewLogger("", "", "")
+	_, err := ph.GetSLIValue(context.Background(), Throughput, "1571649084", "1571649085", logger)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not fetch OAuth2 token")
+	assert.NotContains(t, err.Error(), "wrong-secret")
+	assert.Empty(t, authorizations)
+}
+
+// countingTransport counts the requests sent to the token URL
+type countingTransport struct {
+	tokenURL      string
+	tokenRequests int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.String(), c.tokenURL) {
+		c.tokenRequests++
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestGetSLIValueWithOAuth2AndDifferentTransports(t *testing.T) {
+	tokenRequests := 0
+	tokenServer := newTestTokenServer(t, "3600", &tokenRequests)
+	defer tokenServer.Close()
+	authorizations := []string{}
+	prometheusServer := newTestOAuth2PrometheusServer(&authorizations)
+	defer prometheusServer.Close()
+
+	// e.g. two datasources with the same client, but different TLS or proxy settings
+	transports := []*countingTransport{{tokenURL: tokenServer.URL}, {tokenURL: tokenServer.URL}}
+	logger := keptncommon.NewLogger("", "", "")
+	for _, transport := range transports {
+		ph := NewPrometheusHandler(prometheusServer.URL, "sockshop", "dev", "carts", nil)
+		ph.HTTPClient = &http.Client{Transport: transport}
+		ph.OAuth2 = &OAuth2Config{ClientID: "my-client", ClientSecret: "my-secret", TokenURL: tokenServer.URL, Scopes: []string{"metrics:read"}}
+		_, err := ph.GetSLIValue(context.Background(), Throughput, "1571649084", "1571649085", logger)
+		assert.Nil(t, err)
+	}
+
+	// each transport fetches its own token
+	assert.EqualValues(t, 2, tokenRequests)
+	assert.EqualValues(t, 1, transports[0].tokenRequests)
+	assert.EqualValues(t, 1, transports[1].tokenRequests)
+}
+
+func TestGetSLIValueWithOAuth2AndQueryTimeout(t *testing.T) {
+	release := make(chan struct{})
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer tokenServer.Close()
+	defer close(release)
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.OAuth2 = &OAuth2Config{ClientID: "my-client", ClientSecret: "my-secret", TokenURL: tokenServer.URL}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	logger := keptncommon.NewLogger("", "", "")
+	start := time.Now()
+	_, err := ph.GetSLIValue(ctx, Throughput, "1571649084", "1571649085", logger)
+
+	// the token request is canceled with the query instead of running into a timeout of its own
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "timed out")
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestOAuth2CacheKey(t *testing.T) {
+	config := &OAuth2Config{ClientID: "my-client", ClientSecret: "my-secret", TokenURL: "https://auth.example.com/token"}
+	otherSecret := &OAuth2Config{ClientID: "my-client", ClientSecret: "other-secret", TokenURL: "https://auth.example.com/token"}
+
+	assert.NotContains(t, config.cacheKey(), "my-secret")
+	assert.NotEqual(t, config.cacheKey(), otherSecret.cacheKey())
+}
@@ -0,0 +1,197 @@
+package prometheus
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InstantMode evaluates the query at the end of the evaluation window (/api/v1/query)
+const InstantMode = "instant"
+
+// RangeMode evaluates the query over the whole evaluation window (/api/v1/query_range) and aggregates the samples
+const RangeMode = "range"
+
+const optionMode = "MODE"
+const optionStep = "STEP"
+const optionAggregation = "AGGREGATION"
+const optionBreakdown = "BREAKDOWN"
+const optionEmptyResult = "EMPTY_RESULT"
+const optionTimeout = "TIMEOUT"
+const optionDatasource = "DATASOURCE"
+const optionMerge = "MERGE"
+
+// maxRangePoints is the number of samples a range query returns when no step has been configured
+const maxRangePoints = 60
+
+// options are prepended to a query in the form KEY=value; e.g.: MODE=range;STEP=30s;AGGREGATION=max;<query>
+// a leading KEY= is never valid PromQL, so options can not be confused with the query itself
+var optionRegex = regexp.MustCompile(`^\s*([A-Z_]+)\s*=\s*([^;]*);`)
+
+var datasourceRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// indicatorOptions defines how the query of an indicator is executed and how its result is reduced to one value
+type indicatorOptions struct {
+	Mode        string
+	Step        time.Duration
+	Aggregation string
+	Breakdown   bool
+	// EmptyResultPolicy overrides the policy of the handler if set
+	EmptyResultPolicy *EmptyResultPolicy
+	// Timeout overrides the query timeout of the handler if set
+	Timeout time.Duration
+	// Datasources are the names of the datasources the query is sent to, the default datasource if empty
+	Datasources []string
+	// Merge defines how the values of several datasources are merged into one value
+	Merge string
+	// QueryParameters override the Thanos query parameters of the handler
+	QueryParameters QueryParameters
+}
+
+// parseIndicatorQuery splits a query from the SLI configuration into its options and the actual PromQL query
+func parseIndicatorQuery(rawQuery string) (*indicatorOptions, string, error) {
+	options := &indicatorOptions{
+		Mode:        InstantMode,
+		Aggregation: AggregationAvg,
+	}
+
+	query := rawQuery
+	for {
+		match := optionRegex.FindStringSubmatch(query)
+		if match == nil {
+			break
+		}
+		query = query[len(match[0]):]
+
+		key := match[1]
+		value := strings.TrimSpace(match[2])
+		switch key {
+		case optionMode:
+			if value != InstantMode && value != RangeMode {
+				return nil, "", errors.New("invalid value for option " + key + ": " + value + " (expected " + InstantMode + " or " + RangeMode + ")")
+			}
+			options.Mode = value
+		case optionStep:
+			step, err := parseDuration(value)
+			if err != nil {
+				return nil, "", errors.New("invalid value for option " + key + ": " + err.Error())
+			}
+			options.Step = step
+		case optionAggregation:
+			if err := validateAggregation(value); err != nil {
+				return nil, "", errors.New("invalid value for option " + key + ": " + err.Error())
+			}
+			options.Aggregation = value
+		case optionBreakdown:
+			breakdown, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, "", errors.New("invalid value for option " + key + ": " + value + " (expected true or false)")
+			}
+			options.Breakdown = breakdown
+		case optionEmptyResult:
+			policy, err := ParseEmptyResultPolicy(value)
+			if err != nil {
+				return nil, "", errors.New("invalid value for option " + key + ": " + err.Error())
+			}
+			options.EmptyResultPolicy = &policy
+		case optionTimeout:
+			timeout, err := parseDuration(value)
+			if err != nil {
+				return nil, "", errors.New("invalid value for option " + key + ": " + err.Error())
+			}
+			options.Timeout = timeout
+		case optionDatasource:
+			datasources, err := parseDatasources(value)
+			if err != nil {
+				return nil, "", errors.New("invalid value for option " + key + ": " + err.Error())
+			}
+			options.Datasources = datasources
+		case optionMerge:
+			if err := validateMerge(value); err != nil {
+				return nil, "", errors.New("invalid value for option " + key + ": " + err.Error())
+			}
+			options.Merge = value
+		default:
+			ok, err := parseQueryParameterOption(key, value, &options.QueryParameters)
+			if err != nil {
+				return nil, "", err
+			}
+			if !ok {
+				return nil, "", errors.New("unknown option " + key)
+			}
+		}
+	}
+	if len(options.Datasources) > 1 {
+		if options.Merge == "" {
+			return nil, "", errors.New("option " + optionMerge + " is required for several datasources")
+		}
+		if options.Breakdown {
+			return nil, "", errors.New("option " + optionBreakdown + " can not be combined with several datasources")
+		}
+	}
+	return options, strings.TrimSpace(query), nil
+}
+
+// parseDatasources parses a comma-separated list of datasource names
+func parseDatasources(value string) ([]string, error) {
+	datasources := []string{}
+	seen := map[string]bool{}
+	for _, datasource := range strings.Split(value, ",") {
+		datasource = strings.TrimSpace(datasource)
+		if !datasourceRegex.MatchString(datasource) {
+			return nil, errors.New("invalid datasource name " + datasource + " (expected letters, digits, _ and -)")
+		}
+		if seen[datasource] {
+			return nil, errors.New("datasource " + datasource + " is listed more than once")
+		}
+		seen[datasource] = true
+		datasources = append(datasources, datasource)
+	}
+	return datasources, nil
+}
+
+// parseDuration parses a duration either given as Go duration (e.g. 30s, 1m) or as number of seconds
+func parseDuration(value string) (time.Duration, error) {
+	var step time.Duration
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		step = time.Duration(seconds * float64(time.Second))
+	} else {
+		step, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if step <= 0 {
+		return 0, errors.New("duration must be greater than 0")
+	}
+	return step, nil
+}
+
+// getStep returns the configured step, or a step that splits the evaluation window into maxRangePoints samples
+func (o *indicatorOptions) getStep(start time.Time, end time.Time) time.Duration {
+	if o.Step > 0 {
+		return o.Step
+	}
+	step := (end.Sub(start) / maxRangePoints).Truncate(time.Second)
+	if step < time.Second {
+		return time.Second
+	}
+	return step
+}
+
+func (ph *Handler) getIndicatorOptions(metric string) (*indicatorOptions, error) {
+	options, _, err := parseIndicatorQuery(ph.CustomQueries[metric])
+	return options, err
+}
+
+// GetDatasources returns the names of the datasources of an indicator, none for the default datasource, and how the
+// values of several datasources are merged
+func (ph *Handler) GetDatasources(metric string) ([]string, string, error) {
+	options, err := ph.getIndicatorOptions(metric)
+	if err != nil {
+		return nil, "", err
+	}
+	return options.Datasources, options.Merge, nil
+}
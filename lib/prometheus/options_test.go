@@ -0,0 +1,144 @@
+package prometheus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseIndicatorQueryWithoutOptions(t *testing.T) {
+	options, query, err := parseIndicatorQuery("sum(rate(http_requests_total{job='$SERVICE-$PROJECT-$STAGE'}[$DURATION_SECONDS]))")
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, InstantMode, options.Mode)
+	assert.EqualValues(t, AggregationAvg, options.Aggregation)
+	assert.EqualValues(t, "sum(rate(http_requests_total{job='$SERVICE-$PROJECT-$STAGE'}[$DURATION_SECONDS]))", query)
+}
+
+func TestParseIndicatorQueryWithRangeOptions(t *testing.T) {
+	options, query, err := parseIndicatorQuery("MODE=range; STEP=30s; AGGREGATION=p95; histogram_quantile(0.95,sum(rate(http_response_time_milliseconds_bucket[1m]))by(le))")
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, RangeMode, options.Mode)
+	assert.EqualValues(t, 30*time.Second, options.Step)
+	assert.EqualValues(t, "p95", options.Aggregation)
+	assert.EqualValues(t, "histogram_quantile(0.95,sum(rate(http_response_time_milliseconds_bucket[1m]))by(le))", query)
+}
+
+func TestParseIndicatorQueryWithInvalidOptions(t *testing.T) {
+	tests := []string{
+		"MODE=sometimes;up",
+		"STEP=-1;up",
+		"STEP=abc;up",
+		"AGGREGATION=median;up",
+		"AGGREGATION=p101;up",
+		"AGGREGATION=pNaN;up",
+		"AGGREGATION=pInf;up",
+		"TIMEOUT=0;up",
+		"EMPTY_RESULT=ignore;up",
+		"UNKNOWN=1;up",
+	}
+	for _, test := range tests {
+		_, _, err := parseIndicatorQuery(test)
+		assert.NotNil(t, err, test)
+	}
+}
+
+func TestGetStep(t *testing.T) {
+	start := time.Unix(1571649085, 0)
+
+	options := &indicatorOptions{Step: 15 * time.Second}
+	assert.EqualValues(t, 15*time.Second, options.getStep(start, start.Add(time.Hour)))
+
+	options = &indicatorOptions{}
+	assert.EqualValues(t, time.Minute, options.getStep(start, start.Add(time.Hour)))
+	assert.EqualValues(t, time.Second, options.getStep(start, start.Add(30*time.Second)))
+}
+
+func TestParseIndicatorQueryWithBreakdown(t *testing.T) {
+	options, query, err := parseIndicatorQuery("BREAKDOWN=true;sum(rate(http_requests_total[5m]))by(handler)")
+
+	assert.Nil(t, err)
+	assert.True(t, options.Breakdown)
+	assert.EqualValues(t, "sum(rate(http_requests_total[5m]))by(handler)", query)
+}
+
+func TestParseIndicatorQueryWithTimeout(t *testing.T) {
+	options, query, err := parseIndicatorQuery("TIMEOUT=2m;up")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "up", query)
+	assert.EqualValues(t, 2*time.Minute, options.Timeout)
+
+	options, _, err = parseIndicatorQuery("TIMEOUT=90;up")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 90*time.Second, options.Timeout)
+}
+
+func TestParseIndicatorQueryWithDatasource(t *testing.T) {
+	options, query, err := parseIndicatorQuery("DATASOURCE=thanos;MODE=range;up")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "up", query)
+	assert.EqualValues(t, []string{"thanos"}, options.Datasources)
+	assert.EqualValues(t, RangeMode, options.Mode)
+
+	_, _, err = parseIndicatorQuery("DATASOURCE=;up")
+	assert.EqualError(t, err, "invalid value for option DATASOURCE: invalid datasource name  (expected letters, digits, _ and -)")
+
+	_, _, err = parseIndicatorQuery("DATASOURCE=thanos querier;up")
+	assert.NotNil(t, err)
+}
+
+func TestParseIndicatorQueryWithSeveralDatasources(t *testing.T) {
+	options, query, err := parseIndicatorQuery("DATASOURCE=eu-west, us-east;MERGE=sum;up")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "up", query)
+	assert.EqualValues(t, []string{"eu-west", "us-east"}, options.Datasources)
+	assert.EqualValues(t, MergeSum, options.Merge)
+
+	_, _, err = parseIndicatorQuery("DATASOURCE=eu-west,us-east;up")
+	assert.EqualError(t, err, "option MERGE is required for several datasources")
+
+	_, _, err = parseIndicatorQuery("DATASOURCE=eu-west,us-east;MERGE=max;BREAKDOWN=true;up")
+	assert.EqualError(t, err, "option BREAKDOWN can not be combined with several datasources")
+
+	_, _, err = parseIndicatorQuery("DATASOURCE=eu-west,eu-west;MERGE=max;up")
+	assert.EqualError(t, err, "invalid value for option DATASOURCE: datasource eu-west is listed more than once")
+
+	_, _, err = parseIndicatorQuery("DATASOURCE=eu-west,;MERGE=max;up")
+	assert.NotNil(t, err)
+
+	_, _, err = parseIndicatorQuery("DATASOURCE=eu-west,us-east;MERGE=p95;up")
+	assert.EqualError(t, err, "invalid value for option MERGE: unsupported merge p95 (expected sum, avg, max or min)")
+}
+
+func TestGetDatasources(t *testing.T) {
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.CustomQueries = map[string]string{
+		"cpu_usage":   "DATASOURCE=thanos;sum(rate(container_cpu_usage_seconds_total[5m]))",
+		"requests":    "DATASOURCE=eu-west,us-east;MERGE=sum;sum(rate(http_requests_total[5m]))",
+		"error_rate":  "sum(rate(http_requests_total{status=~\"5..\"}[5m]))",
+		"invalid_opt": "DATASOURCE=thanos/querier;up",
+	}
+
+	datasources, merge, err := ph.GetDatasources("cpu_usage")
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"thanos"}, datasources)
+	assert.EqualValues(t, "", merge)
+
+	datasources, merge, err = ph.GetDatasources("requests")
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"eu-west", "us-east"}, datasources)
+	assert.EqualValues(t, MergeSum, merge)
+
+	datasources, _, err = ph.GetDatasources("error_rate")
+	assert.Nil(t, err)
+	assert.Empty(t, datasources)
+
+	// default indicators without custom query use the default datasource
+	datasources, _, err = ph.GetDatasources("throughput")
+	assert.Nil(t, err)
+	assert.Empty(t, datasources)
+
+	_, _, err = ph.GetDatasources("invalid_opt")
+	assert.NotNil(t, err)
+}
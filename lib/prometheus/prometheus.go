@@ -1,16 +1,15 @@
 package prometheus
 
 import (
-	"crypto/tls"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
 	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
-	"io/ioutil"
 	"math"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,29 +21,47 @@ const RequestLatencyP50 = "response_time_p50"
 const RequestLatencyP90 = "response_time_p90"
 const RequestLatencyP95 = "response_time_p95"
 
-type prometheusResponse struct {
-	Status string `json:"status"`
-	Data   struct {
-		ResultType string `json:"resultType"`
-		Result     []struct {
-			Metric struct {
-			} `json:"metric"`
-			Value []interface{} `json:"value"`
-		} `json:"result"`
-	} `json:"data"`
-}
+// DefaultQueryTimeout is the time a query may take unless the handler or the indicator defines another timeout
+const DefaultQueryTimeout = 30 * time.Second
 
-// Handler interacts with a prometheus API endpoint
+// Handler interacts with a prometheus API endpoint. A handler can be used for several queries at the same time
 type Handler struct {
 	ApiURL        string
-	Username      string
-	Password      string
 	Project       string
 	Stage         string
 	Service       string
 	HTTPClient    *http.Client
 	CustomFilters []*keptnv2.SLIFilter
 	CustomQueries map[string]string
+	// Username and Password are sent as basic authentication with each request, unless a bearer token is configured
+	Username string
+	Password string
+	// BearerToken is sent in the Authorization header of each request
+	BearerToken string
+	// BearerTokenFile is read for each request and sent in the Authorization header, unless BearerToken is set
+	BearerTokenFile string
+	// OAuth2 defines client credentials for an access token that is sent in the Authorization header of each request
+	OAuth2 *OAuth2Config
+	// SigV4 defines how each request is signed for Amazon Managed Service for Prometheus
+	SigV4 *SigV4Config
+	// Headers are added to each request, e.g. for API gateways in front of Prometheus
+	Headers map[string]string
+	// TenantID is sent in the X-Scope-OrgID header of each request. It supports the same templates as queries
+	TenantID string
+	// EmptyResultPolicy applies to all indicators that do not define their own policy
+	EmptyResultPolicy EmptyResultPolicy
+	// QueryTimeout applies to all indicators that do not define their own timeout
+	QueryTimeout time.Duration
+	// MaxAttempts is the number of times a query is sent if it fails transiently
+	MaxAttempts int
+	// RetryBackoff is the delay before the first retry, it doubles with each further retry
+	RetryBackoff time.Duration
+	// ReplicaURLs are queried in this order if ApiURL, or the previous replica, fails with a connection error or 5xx
+	ReplicaURLs []string
+	// HealthCheckPath, e.g. /-/ready, is used to query healthy replicas first. Failover keeps the configured order if empty
+	HealthCheckPath string
+	// QueryParameters are sent to Thanos with each query, unless an indicator overrides them
+	QueryParameters QueryParameters
 }
 
 // NewPrometheusHandler returns a new prometheus handler that interacts with the Prometheus REST API
@@ -56,116 +73,259 @@ func NewPrometheusHandler(apiURL string, project string, stage string, service s
 		Service:       service,
 		HTTPClient:    &http.Client{},
 		CustomFilters: customFilters,
+		QueryTimeout:  DefaultQueryTimeout,
+		MaxAttempts:   DefaultMaxAttempts,
+		RetryBackoff:  DefaultRetryBackoff,
 	}
 
 	return ph
 }
 
-// GetSLIValue retrieves the specified value via the Prometheus API
-func (ph *Handler) GetSLIValue(metric string, start string, end string, logger keptncommon.LoggerInterface) (float64, error) {
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+// GetSLIValue retrieves the specified value via the Prometheus API. Credentials are removed from log lines and errors
+func (ph *Handler) GetSLIValue(ctx context.Context, metric string, start string, end string, logger keptncommon.LoggerInterface) (float64, error) {
+	redactor := ph.getRedactor()
+	value, err := ph.getSLIValue(ctx, metric, start, end, &redactingLogger{logger: logger, redactor: redactor})
+	return value, redactor.redactError(err)
+}
 
-	startUnix, err := parseUnixTimestamp(start)
+func (ph *Handler) getSLIValue(ctx context.Context, metric string, start string, end string, logger keptncommon.LoggerInterface) (float64, error) {
+	options, err := ph.getIndicatorOptions(metric)
 	if err != nil {
 		return 0, err
 	}
-	endUnix, _ := parseUnixTimestamp(end)
+	result, err := ph.executeQuery(ctx, metric, start, end, logger)
 	if err != nil {
 		return 0, err
 	}
-	query, err := ph.getMetricQuery(metric, startUnix, endUnix)
+	value, _, err := result.getSingleValue(ph.getEmptyResultPolicy(options), logger)
+	return value, err
+}
+
+// GetSLIResults retrieves the specified indicator via the Prometheus API. If the breakdown option is set for the indicator,
+// one result per series is returned, e.g. response_time_p95{handler="ItemsController"}. Warnings returned by Prometheus,
+// the applied empty result policy and the number of retries are added to the message of the results. Credentials are
+// removed from log lines, messages and errors
+func (ph *Handler) GetSLIResults(ctx context.Context, metric string, start string, end string, logger keptncommon.LoggerInterface) ([]*keptnv2.SLIResult, error) {
+	redactor := ph.getRedactor()
+	sliResults, err := ph.getSLIResults(ctx, metric, start, end, &redactingLogger{logger: logger, redactor: redactor})
 	if err != nil {
-		return 0, err
+		return nil, redactor.redactError(err)
+	}
+	for _, sliResult := range sliResults {
+		sliResult.Message = redactor.redact(sliResult.Message)
 	}
-	queryString := ph.ApiURL + "/api/v1/query?query=" + url.QueryEscape(query) + "&time=" + strconv.FormatInt(endUnix.Unix(), 10)
-	logger.Info("Generated query: /api/v1/query?query=" + query + "&time=" + strconv.FormatInt(endUnix.Unix(), 10))
+	return sliResults, nil
+}
 
-	req, err := http.NewRequest("GET", queryString, nil)
-	req.Header.Set("Content-Type", "application/json")
+func (ph *Handler) getSLIResults(ctx context.Context, metric string, start string, end string, logger keptncommon.LoggerInterface) ([]*keptnv2.SLIResult, error) {
+	options, err := ph.getIndicatorOptions(metric)
+	if err != nil {
+		return nil, err
+	}
 
-	resp, err := ph.HTTPClient.Do(req)
+	result, err := ph.executeQuery(ctx, metric, start, end, logger)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	defer resp.Body.Close()
+	message := joinMessages(result.getWarningsMessage(), getRetriesMessage(result.Retries))
 
-	body, _ := ioutil.ReadAll(resp.Body)
-	if resp.StatusCode != 200 {
-		return 0, errors.New("metric could not be received")
+	if !options.Breakdown || len(result.Values) == 0 {
+		value, valueMessage, err := result.getSingleValue(ph.getEmptyResultPolicy(options), logger)
+		if err != nil {
+			return nil, err
+		}
+		return []*keptnv2.SLIResult{{Metric: metric, Value: value, Success: true, Message: joinMessages(valueMessage, message)}}, nil
+	}
+
+	sliResults := []*keptnv2.SLIResult{}
+	for _, value := range result.Values {
+		sliResults = append(sliResults, &keptnv2.SLIResult{
+			Metric:  getSeriesMetricName(metric, value.Labels),
+			Value:   value.Value,
+			Success: true,
+			Message: message,
+		})
 	}
+	sort.Slice(sliResults, func(i, j int) bool {
+		return sliResults[i].Metric < sliResults[j].Metric
+	})
+	logger.Info(fmt.Sprintf("Prometheus Result contains %d series", len(sliResults)))
+	return sliResults, nil
+}
 
-	prometheusResult := &prometheusResponse{}
+// executeQuery executes the query of an indicator and returns one value per series of the result. The query is
+// canceled if either ctx is done or the query timeout of the indicator expires
+func (ph *Handler) executeQuery(ctx context.Context, metric string, start string, end string, logger keptncommon.LoggerInterface) (*queryResult, error) {
+	startUnix, err := parseUnixTimestamp(start)
+	if err != nil {
+		return nil, err
+	}
+	endUnix, err := parseUnixTimestamp(end)
+	if err != nil {
+		return nil, err
+	}
+	options, err := ph.getIndicatorOptions(metric)
+	if err != nil {
+		return nil, err
+	}
+	query, err := ph.getMetricQuery(metric, startUnix, endUnix)
+	if err != nil {
+		return nil, err
+	}
 
-	err = json.Unmarshal(body, prometheusResult)
+	parameters, err := ph.getQueryParameters(options)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	queryPath := ph.getQueryPath(query, options, startUnix, endUnix) + parameters.encode()
+	logger.Info("Generated query: " + queryPath)
+
+	timeout := ph.getQueryTimeout(options)
+	queryCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	if len(prometheusResult.Data.Result) == 0 || len(prometheusResult.Data.Result[0].Value) == 0 {
-		logger.Info("Prometheus Result is 0, returning value 0")
-		// for the error rate query, the result is received with no value if the error rate is 0, so we have to assume that's OK at this point
-		return 0, nil
+	statusCode, body, retries, err := ph.sendQuery(queryCtx, queryPath, logger)
+	if err != nil {
+		return nil, withRetries(getTimeoutError(ctx, queryCtx, timeout, err), retries)
+	}
+	prometheusResult, err := parsePrometheusResponse(statusCode, body)
+	if err != nil {
+		return nil, withRetries(err, retries)
+	}
+	for _, warning := range prometheusResult.Warnings {
+		logger.Info("Prometheus returned warning: " + warning)
 	}
 
-	parsedValue := fmt.Sprintf("%v", prometheusResult.Data.Result[0].Value[1])
-	floatValue, err := strconv.ParseFloat(parsedValue, 64)
-	logger.Info(fmt.Sprintf("Prometheus Result is %v", floatValue))
+	values, err := prometheusResult.getSeriesValues(options)
 	if err != nil {
-		return 0, nil
+		return nil, err
 	}
-	return floatValue, nil
+	result := &queryResult{
+		Values:   values,
+		Warnings: prometheusResult.Warnings,
+		Retries:  retries,
+		// with partial responses allowed, Thanos reports unavailable stores as warnings
+		Partial: parameters.isPartialResponseAllowed() && len(prometheusResult.Warnings) > 0,
+	}
+	return result, nil
+}
+
+// getQueryTimeout returns the timeout of the indicator, or the timeout of the handler if the indicator has none
+func (ph *Handler) getQueryTimeout(options *indicatorOptions) time.Duration {
+	if options.Timeout > 0 {
+		return options.Timeout
+	}
+	if ph.QueryTimeout > 0 {
+		return ph.QueryTimeout
+	}
+	return DefaultQueryTimeout
+}
+
+// getTimeoutError reports whether a failed request has been aborted because the whole evaluation (ctx) or the query
+// itself (queryCtx) timed out, and returns err otherwise
+func getTimeoutError(ctx context.Context, queryCtx context.Context, timeout time.Duration, err error) error {
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return errors.New("timed out: evaluation deadline exceeded before the query completed")
+	case ctx.Err() == context.Canceled:
+		return errors.New("evaluation canceled before the query completed")
+	case queryCtx.Err() == context.DeadlineExceeded:
+		return errors.New("timed out: query did not complete within " + timeout.String())
+	}
+	return err
+}
+
+// withRetries adds the number of retries to the error of a query that has been retried
+func withRetries(err error, retries int) error {
+	if retries == 0 {
+		return err
+	}
+	return errors.New(err.Error() + " (after " + strconv.Itoa(retries) + " retries)")
+}
+
+// getQueryPath returns the API path and parameters for either an instant query at the end of the evaluation window,
+// or a range query over the whole evaluation window
+func (ph *Handler) getQueryPath(query string, options *indicatorOptions, start time.Time, end time.Time) string {
+	if options.Mode == RangeMode {
+		step := options.getStep(start, end)
+		return "/api/v1/query_range?query=" + url.QueryEscape(query) +
+			"&start=" + strconv.FormatInt(start.Unix(), 10) +
+			"&end=" + strconv.FormatInt(end.Unix(), 10) +
+			"&step=" + strconv.FormatFloat(step.Seconds(), 'f', -1, 64)
+	}
+	return "/api/v1/query?query=" + url.QueryEscape(query) + "&time=" + strconv.FormatInt(end.Unix(), 10)
+}
+
+// getSeriesMetricName builds the metric name of a series from the indicator and the labels of the series,
+// e.g. response_time_p95{handler="ItemsController",method="GET"}
+func getSeriesMetricName(metric string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return metric
+	}
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	labelPairs := []string{}
+	for _, key := range keys {
+		labelPairs = append(labelPairs, key+"="+strconv.Quote(labels[key]))
+	}
+	return metric + "{" + strings.Join(labelPairs, ",") + "}"
 }
 
+// getMetricQuery returns the final query of an indicator and checks its PromQL syntax before it is sent to Prometheus
 func (ph *Handler) getMetricQuery(metric string, start time.Time, end time.Time) (string, error) {
-	query := ph.CustomQueries[metric]
-	if query != "" {
-		query = ph.replaceQueryParameters(query, start, end)
+	_, query, err := parseIndicatorQuery(ph.CustomQueries[metric])
+	if err != nil {
+		return "", err
+	}
+	if query, err = ph.buildMetricQuery(metric, query, start, end); err != nil {
+		return "", err
+	}
 
-		return query, nil
+	if err := ValidateQuery(query); err != nil {
+		return "", errors.New("invalid PromQL query: " + err.Error())
+	}
+	return query, nil
+}
+
+func (ph *Handler) buildMetricQuery(metric string, query string, start time.Time, end time.Time) (string, error) {
+	if query != "" {
+		return ph.renderQuery(query, start, end)
 	}
 
 	switch metric {
 	case Throughput:
-		return ph.getThroughputQuery(start, end), nil
+		return ph.getThroughputQuery(start, end)
 	case ErrorRate:
-		return ph.getErrorRateQuery(start, end), nil
+		return ph.getErrorRateQuery(start, end)
 	case RequestLatencyP50:
-		return ph.getRequestLatencyQuery("50", start, end), nil
+		return ph.getRequestLatencyQuery("50", start, end)
 	case RequestLatencyP90:
-		return ph.getRequestLatencyQuery("90", start, end), nil
+		return ph.getRequestLatencyQuery("90", start, end)
 	case RequestLatencyP95:
-		return ph.getRequestLatencyQuery("95", start, end), nil
+		return ph.getRequestLatencyQuery("95", start, end)
 	default:
 		return "", errors.New("unsupported SLI")
 	}
 }
 
-func (ph *Handler) replaceQueryParameters(query string, start time.Time, end time.Time) string {
-	for _, filter := range ph.CustomFilters {
-		filter.Value = strings.Replace(filter.Value, "'", "", -1)
-		filter.Value = strings.Replace(filter.Value, "\"", "", -1)
-		query = strings.Replace(query, "$"+filter.Key, filter.Value, -1)
-		query = strings.Replace(query, "$"+strings.ToUpper(filter.Key), filter.Value, -1)
-	}
-	query = strings.Replace(query, "$PROJECT", ph.Project, -1)
-	query = strings.Replace(query, "$STAGE", ph.Stage, -1)
-	query = strings.Replace(query, "$SERVICE", ph.Service, -1)
-	query = strings.Replace(query, "$project", ph.Project, -1)
-	query = strings.Replace(query, "$stage", ph.Stage, -1)
-	query = strings.Replace(query, "$service", ph.Service, -1)
-	durationString := strconv.FormatInt(getDurationInSeconds(start, end), 10) + "s"
-
-	query = strings.Replace(query, "$DURATION_SECONDS", durationString, -1)
+// getCustomQuery returns the user-defined query of an indicator without its options
+func (ph *Handler) getCustomQuery(metric string) string {
+	_, query, err := parseIndicatorQuery(ph.CustomQueries[metric])
+	if err != nil {
+		return ""
+	}
 	return query
 }
 
-func (ph *Handler) getThroughputQuery(start time.Time, end time.Time) string {
-	if ph.CustomQueries != nil && ph.CustomQueries["throughput"] != "" {
-		query := ph.CustomQueries["throughput"]
-		query = ph.replaceQueryParameters(query, start, end)
-		return query
+func (ph *Handler) getThroughputQuery(start time.Time, end time.Time) (string, error) {
+	if query := ph.getCustomQuery("throughput"); query != "" {
+		return ph.renderQuery(query, start, end)
 	}
-	return ph.getDefaultThroughputQuery(start, end)
+	return ph.getDefaultThroughputQuery(start, end), nil
 }
 
 func (ph *Handler) getDefaultThroughputQuery(start time.Time, end time.Time) string {
@@ -192,13 +352,11 @@ func (ph *Handler) getDefaultThroughputQuery(start time.Time, end time.Time) str
 	return "sum(rate(http_requests_total{" + filterExpr + "}[" + durationString + "]))"
 }
 
-func (ph *Handler) getErrorRateQuery(start time.Time, end time.Time) string {
-	if ph.CustomQueries != nil && ph.CustomQueries["error_rate"] != "" {
-		query := ph.CustomQueries["error_rate"]
-		query = ph.replaceQueryParameters(query, start, end)
-		return query
+func (ph *Handler) getErrorRateQuery(start time.Time, end time.Time) (string, error) {
+	if query := ph.getCustomQuery("error_rate"); query != "" {
+		return ph.renderQuery(query, start, end)
 	}
-	return ph.getDefaultErrorRateQuery(start, end)
+	return ph.getDefaultErrorRateQuery(start, end), nil
 }
 
 func (ph *Handler) getDefaultErrorRateQuery(start time.Time, end time.Time) string {
@@ -235,28 +393,27 @@ func (ph *Handler) getDefaultErrorRateQuery(start time.Time, end time.Time) stri
 	return "sum(rate(http_requests_total{" + filterExpr + ",status!~'2..'}[" + durationString + "]))/sum(rate(http_requests_total{" + filterExpr + "}[" + durationString + "]))"
 }
 
-func (ph *Handler) getRequestLatencyQuery(percentile string, start time.Time, end time.Time) string {
+func (ph *Handler) getRequestLatencyQuery(percentile string, start time.Time, end time.Time) (string, error) {
 	if ph.CustomQueries != nil {
 		query := ""
 		switch percentile {
 		case "50":
-			query = ph.CustomQueries["response_time_p50"]
+			query = ph.getCustomQuery("response_time_p50")
 			break
 		case "90":
-			query = ph.CustomQueries["response_time_p90"]
+			query = ph.getCustomQuery("response_time_p90")
 			break
 		case "95":
-			query = ph.CustomQueries["response_time_p95"]
+			query = ph.getCustomQuery("response_time_p95")
 			break
 		default:
 			query = ""
 		}
 		if query != "" {
-			query = ph.replaceQueryParameters(query, start, end)
-			return query
+			return ph.renderQuery(query, start, end)
 		}
 	}
-	return ph.getDefaultRequestLatencyQuery(start, end, percentile)
+	return ph.getDefaultRequestLatencyQuery(start, end, percentile), nil
 }
 
 func (ph *Handler) getDefaultRequestLatencyQuery(start time.Time, end time.Time, percentile string) string {
@@ -283,6 +440,8 @@ func (ph *Handler) getDefaultRequestLatencyQuery(start time.Time, end time.Time,
 	return "histogram_quantile(0." + percentile + ",sum(rate(http_response_time_milliseconds_bucket{" + filterExpr + "}[" + durationString + "]))by(le))"
 }
 
+// getDefaultFilterExpression builds the label matchers of the default queries from the custom filters. The filters are
+// not modified, so a handler can be used for several queries at the same time
 func (ph *Handler) getDefaultFilterExpression() string {
 	filterExpression := ""
 	jobFilterFound := false
@@ -297,12 +456,11 @@ func (ph *Handler) getDefaultFilterExpression() string {
 			value: ItemsController
 			*/
 			if !strings.HasPrefix(filter.Value, "=") && !strings.HasPrefix(filter.Value, "!=") && !strings.HasPrefix(filter.Value, "=~") && !strings.HasPrefix(filter.Value, "!~") {
-				filter.Value = strings.Replace(filter.Value, "'", "", -1)
-				filter.Value = strings.Replace(filter.Value, "\"", "", -1)
+				value := stripQuotes(filter.Value)
 				if filterExpression != "" {
-					filterExpression = filterExpression + "," + filter.Key + "='" + filter.Value + "'"
+					filterExpression = filterExpression + "," + filter.Key + "='" + value + "'"
 				} else {
-					filterExpression = filter.Key + "='" + filter.Value + "'"
+					filterExpression = filter.Key + "='" + value + "'"
 				}
 
 			} else {
@@ -316,11 +474,11 @@ func (ph *Handler) getDefaultFilterExpression() string {
 				key: handler
 				value: =~.+ItemsController|.+VersionController
 				*/
-				filter.Value = strings.Replace(filter.Value, "\"", "'", -1)
+				value := strings.Replace(filter.Value, "\"", "'", -1)
 				if filterExpression != "" {
-					filterExpression = filterExpression + "," + filter.Key + filter.Value
+					filterExpression = filterExpression + "," + filter.Key + value
 				} else {
-					filterExpression = filter.Key + filter.Value
+					filterExpression = filter.Key + value
 				}
 			}
 		}
@@ -33,7 +33,8 @@ func TestGetErrorRateQueryWithoutFilter(t *testing.T) {
 
 	start := time.Unix(1571649084, 0)
 	end := time.Unix(1571649085, 0)
-	query := ph.getErrorRateQuery(start, end)
+	query, err := ph.getErrorRateQuery(start, end)
+	assert.Nil(t, err)
 
 	expectedQuery := "sum(rate(http_requests_total{job='carts-sockshop-dev-canary',status!~'2..'}[1s]))/sum(rate(http_requests_total{job='carts-sockshop-dev-canary'}[1s]))"
 
@@ -55,7 +56,8 @@ func TestGetErrorRateQueryWithFilter(t *testing.T) {
 
 	start := time.Unix(1571649084, 0)
 	end := time.Unix(1571649085, 0)
-	query := ph.getErrorRateQuery(start, end)
+	query, err := ph.getErrorRateQuery(start, end)
+	assert.Nil(t, err)
 
 	expectedQuery := "sum(rate(http_requests_total{job='carts-sockshop-dev-canary',handler=~'ItemsController',status!~'2..'}[1s]))/sum(rate(http_requests_total{job='carts-sockshop-dev-canary',handler=~'ItemsController'}[1s]))"
 
@@ -82,7 +84,8 @@ func TestGetCustomErrorRateQueryWithFilter(t *testing.T) {
 
 	start := time.Unix(1571649084, 0)
 	end := time.Unix(1571649085, 0)
-	query := ph.getErrorRateQuery(start, end)
+	query, err := ph.getErrorRateQuery(start, end)
+	assert.Nil(t, err)
 
 	expectedQuery := "sum(rate(my_custom_metric{job='carts-sockshop-dev',handler=~'ItemsController',status!~'2..'}[1s]))/sum(rate(my_custom_metric{job='carts-sockshop-dev',handler=~'ItemsController'}[1s]))"
 
@@ -96,7 +99,8 @@ func TestGetThroughputQuery(t *testing.T) {
 
 	start := time.Unix(1571649084, 0)
 	end := time.Unix(1571649085, 0)
-	query := ph.getThroughputQuery(start, end)
+	query, err := ph.getThroughputQuery(start, end)
+	assert.Nil(t, err)
 
 	expectedQuery := "sum(rate(http_requests_total{job='carts-sockshop-dev-canary'}[1s]))"
 
@@ -122,7 +126,8 @@ func TestGetCustomThroughputQueryWithFilter(t *testing.T) {
 
 	start := time.Unix(1571649084, 0)
 	end := time.Unix(1571649085, 0)
-	query := ph.getThroughputQuery(start, end)
+	query, err := ph.getThroughputQuery(start, end)
+	assert.Nil(t, err)
 
 	expectedQuery := "rate(my_custom_metric{job='carts-sockshop-dev',handler=~'ItemsController'}[1s])"
 
@@ -136,7 +141,9 @@ func TestGetRequestLatencyQuery(t *testing.T) {
 
 	start := time.Unix(1571649084, 0)
 	end := time.Unix(1571649085, 0)
-	query := ph.getRequestLatencyQuery("95", start, end)
+	query, err := ph.getRequestLatencyQuery("95", start, end)
+
+	assert.Nil(t, err)
 
 	expectedQuery := "histogram_quantile(0.95,sum(rate(http_response_time_milliseconds_bucket{job='carts-sockshop-dev-canary'}[1s]))by(le))"
 
@@ -163,7 +170,9 @@ func TestGetCustomResponseTimeQueryWithFilter(t *testing.T) {
 
 	start := time.Unix(1571649084, 0)
 	end := time.Unix(1571649085, 0)
-	query := ph.getRequestLatencyQuery("50", start, end)
+	query, err := ph.getRequestLatencyQuery("50", start, end)
+
+	assert.Nil(t, err)
 
 	expectedQuery := "histogram_quantile(0.50,sum(rate(my_custom_response_time_metric{job='carts-sockshop-dev'}[1s]))by(le))"
 
@@ -292,6 +301,25 @@ func TestGetDefaultFilterExpressionWithDoubleQuote(t *testing.T) {
 	}
 }
 
+func TestGetDefaultFilterExpressionDoesNotModifyFilters(t *testing.T) {
+
+	var customFilters []*keptnv2.SLIFilter
+
+	customFilters = append(customFilters, &keptnv2.SLIFilter{
+		Key:   "handler",
+		Value: "\"ItemsController\"",
+	}, &keptnv2.SLIFilter{
+		Key:   "method",
+		Value: "!=\"POST\"",
+	})
+
+	ph := NewPrometheusHandler("prometheus", "sockshop", "dev", "carts", customFilters)
+
+	assert.EqualValues(t, "job='carts-sockshop-dev-canary',handler='ItemsController',method!='POST'", ph.getDefaultFilterExpression())
+	assert.EqualValues(t, "\"ItemsController\"", customFilters[0].Value)
+	assert.EqualValues(t, "!=\"POST\"", customFilters[1].Value)
+}
+
 func TestGetSLIValue(t *testing.T) {
 
 	okResponse := `{
@@ -323,7 +351,7 @@ func TestGetSLIValue(t *testing.T) {
 	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
 	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
 	logger := keptncommon.NewLogger("", "", "")
-	value, _ := ph.GetSLIValue(Throughput, start, end, logger)
+	value, _ := ph.GetSLIValue(context.Background(), Throughput, start, end, logger)
 
 	assert.EqualValues(t, value, 0.20111420612813372)
 }
@@ -351,11 +379,99 @@ func TestGetSLIValueWithEmptyResult(t *testing.T) {
 	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
 	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
 	logger := keptncommon.NewLogger("", "", "")
-	value, _ := ph.GetSLIValue(Throughput, start, end, logger)
+	value, _ := ph.GetSLIValue(context.Background(), Throughput, start, end, logger)
 
 	assert.EqualValues(t, value, 0.0)
 }
 
+func TestGetSLIResultsWithEmptyResultPolicy(t *testing.T) {
+
+	okResponse := `{
+		    "status": "success",
+		    "data": {
+		        "resultType": "vector",
+		        "result": []
+		    }
+		}`
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(okResponse))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.EmptyResultPolicy = EmptyResultPolicy{Action: EmptyResultFail}
+	ph.CustomQueries = map[string]string{
+		"error_rate": "EMPTY_RESULT=warn;sum(rate(http_requests_total{status=~\"5..\"}[5m]))",
+	}
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+
+	_, err := ph.GetSLIResults(context.Background(), Throughput, start, end, logger)
+	assert.EqualError(t, err, "query returned no data (empty result policy: fail)")
+
+	results, err := ph.GetSLIResults(context.Background(), "error_rate", start, end, logger)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(results))
+	assert.EqualValues(t, 0.0, results[0].Value)
+	assert.True(t, results[0].Success)
+	assert.EqualValues(t, "WARNING: query returned no data, reporting 0 (empty result policy: warn)", results[0].Message)
+}
+
+func TestGetSLIValueWithQueryTimeout(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.CustomQueries = map[string]string{
+		"throughput": "TIMEOUT=50ms;sum(rate(http_requests_total[5m]))",
+	}
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	_, err := ph.GetSLIValue(context.Background(), Throughput, start, end, logger)
+
+	assert.EqualError(t, err, "timed out: query did not complete within 50ms")
+}
+
+func TestGetSLIValueWithEvaluationTimeout(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := ph.GetSLIValue(ctx, Throughput, start, end, logger)
+
+	assert.EqualError(t, err, "timed out: evaluation deadline exceeded before the query completed")
+}
+
 func TestGetSLIValueWithErrorResponse(t *testing.T) {
 	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// w.Write([]byte(response))
@@ -371,8 +487,225 @@ func TestGetSLIValueWithErrorResponse(t *testing.T) {
 	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
 	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
 	logger := keptncommon.NewLogger("", "", "")
-	value, err := ph.GetSLIValue(Throughput, start, end, logger)
+	value, err := ph.GetSLIValue(context.Background(), Throughput, start, end, logger)
 
 	assert.EqualValues(t, value, 0.0)
 	assert.NotNil(t, err, nil)
 }
+
+func TestGetSLIValueWithRangeQuery(t *testing.T) {
+
+	okResponse := `{
+		    "status": "success",
+		    "data": {
+		        "resultType": "matrix",
+		        "result": [
+		            {
+		                "metric": {},
+		                "values": [
+		                    [1571649025, "12.5"],
+		                    [1571649055, "42.1"],
+		                    [1571649085, "20.3"]
+		                ]
+		            }
+		        ]
+		    }
+		}`
+
+	var requestedPath string
+	var requestedQuery map[string][]string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Path
+		requestedQuery = r.URL.Query()
+		w.Write([]byte(okResponse))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.CustomQueries = map[string]string{
+		"response_time_p95_max": "MODE=range;STEP=30s;AGGREGATION=max;histogram_quantile(0.95,sum(rate(http_response_time_milliseconds_bucket{job='$SERVICE-$PROJECT-$STAGE'}[1m]))by(le))",
+	}
+
+	start := strconv.FormatInt(time.Unix(1571649025, 0).UTC().Unix(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().Unix(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	value, err := ph.GetSLIValue(context.Background(), "response_time_p95_max", start, end, logger)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 42.1, value)
+	assert.EqualValues(t, "/api/v1/query_range", requestedPath)
+	assert.EqualValues(t, []string{"1571649025"}, requestedQuery["start"])
+	assert.EqualValues(t, []string{"1571649085"}, requestedQuery["end"])
+	assert.EqualValues(t, []string{"30"}, requestedQuery["step"])
+	assert.EqualValues(t, []string{"histogram_quantile(0.95,sum(rate(http_response_time_milliseconds_bucket{job='carts-sockshop-dev'}[1m]))by(le))"}, requestedQuery["query"])
+}
+
+func TestGetSLIResultsWithBreakdown(t *testing.T) {
+
+	okResponse := `{
+		    "status": "success",
+		    "data": {
+		        "resultType": "vector",
+		        "result": [
+		            {
+		                "metric": {"handler": "VersionController"},
+		                "value": [1571649085, "2.5"]
+		            },
+		            {
+		                "metric": {"handler": "ItemsController", "method": "GET"},
+		                "value": [1571649085, "0.5"]
+		            }
+		        ]
+		    }
+		}`
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(okResponse))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.CustomQueries = map[string]string{
+		"throughput": "BREAKDOWN=true;sum(rate(http_requests_total{job='$SERVICE-$PROJECT-$STAGE'}[$DURATION_SECONDS]))by(handler,method)",
+	}
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().Unix(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().Unix(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	sliResults, err := ph.GetSLIResults(context.Background(), Throughput, start, end, logger)
+
+	assert.Nil(t, err)
+	assert.Len(t, sliResults, 2)
+	assert.EqualValues(t, `throughput{handler="ItemsController",method="GET"}`, sliResults[0].Metric)
+	assert.EqualValues(t, 0.5, sliResults[0].Value)
+	assert.True(t, sliResults[0].Success)
+	assert.EqualValues(t, `throughput{handler="VersionController"}`, sliResults[1].Metric)
+	assert.EqualValues(t, 2.5, sliResults[1].Value)
+	assert.True(t, sliResults[1].Success)
+}
+
+func TestGetSLIValueWithMultipleSeries(t *testing.T) {
+
+	okResponse := `{
+		    "status": "success",
+		    "data": {
+		        "resultType": "vector",
+		        "result": [
+		            {
+		                "metric": {"handler": "VersionController"},
+		                "value": [1571649085, "2.5"]
+		            },
+		            {
+		                "metric": {"handler": "ItemsController"},
+		                "value": [1571649085, "0.5"]
+		            }
+		        ]
+		    }
+		}`
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(okResponse))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().Unix(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().Unix(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	value, err := ph.GetSLIValue(context.Background(), Throughput, start, end, logger)
+
+	assert.EqualValues(t, 0.0, value)
+	assert.NotNil(t, err)
+}
+
+func TestGetSLIValueWithInvalidQuery(t *testing.T) {
+	requested := false
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.CustomQueries = map[string]string{
+		"throughput": "sum(rate(http_requests_total{job='$SERVICE-$PROJECT-$STAGE'}[$DURATION_SECONDS])",
+	}
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().Unix(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().Unix(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	_, err := ph.GetSLIValue(context.Background(), Throughput, start, end, logger)
+
+	assert.EqualValues(t, "invalid PromQL query: line 1, column 4: unclosed left parenthesis", err.Error())
+	assert.False(t, requested)
+}
+
+func TestGetSLIResultsWithWarnings(t *testing.T) {
+
+	okResponse := `{
+		    "status": "success",
+		    "warnings": ["PromQL info: metric might not be a counter", "partial result"],
+		    "data": {
+		        "resultType": "vector",
+		        "result": [
+		            {
+		                "metric": {},
+		                "value": [1571649085, "0.5"]
+		            }
+		        ]
+		    }
+		}`
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(okResponse))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().Unix(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().Unix(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	sliResults, err := ph.GetSLIResults(context.Background(), Throughput, start, end, logger)
+
+	assert.Nil(t, err)
+	assert.Len(t, sliResults, 1)
+	assert.EqualValues(t, 0.5, sliResults[0].Value)
+	assert.True(t, sliResults[0].Success)
+	assert.EqualValues(t, "Prometheus warnings: PromQL info: metric might not be a counter; partial result", sliResults[0].Message)
+}
+
+func TestGetSLIValueWithBadDataResponse(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"exceeded maximum resolution of 11,000 points per timeseries"}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().Unix(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().Unix(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	_, err := ph.GetSLIValue(context.Background(), Throughput, start, end, logger)
+
+	assert.EqualValues(t, "Prometheus query failed with status 400 (bad_data): exceeded maximum resolution of 11,000 points per timeseries", err.Error())
+}
@@ -0,0 +1,618 @@
+package prometheus
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// QueryParseError describes why a PromQL query could not be parsed and where in the query the problem occurred
+type QueryParseError struct {
+	// Position is the byte offset of the problem within the query
+	Position int
+	Line     int
+	Column   int
+	Reason   string
+}
+
+func (e *QueryParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Reason)
+}
+
+// ValidateQuery checks the PromQL syntax of a query without sending it to Prometheus. The query is parsed with the
+// grammar of PromQL, but types, function names and numbers of arguments are not checked, so queries using functions of
+// newer Prometheus versions, Thanos or VictoriaMetrics are left to Prometheus
+func ValidateQuery(query string) error {
+	p := &promQLParser{input: query}
+	if err := p.tokenize(); err != nil {
+		return err
+	}
+	if p.peek().typ == promQLEOF {
+		return p.errorAt(p.peek().pos, "no expression found in input")
+	}
+	if _, err := p.parseExpr(0); err != nil {
+		return err
+	}
+	if token := p.peek(); token.typ != promQLEOF {
+		return p.errorAt(token.pos, "unexpected "+token.String())
+	}
+	return nil
+}
+
+type promQLTokenType int
+
+const (
+	promQLEOF promQLTokenType = iota
+	promQLIdentifier
+	promQLNumber
+	promQLDuration
+	promQLString
+	promQLPunctuation
+)
+
+type promQLToken struct {
+	typ   promQLTokenType
+	value string
+	pos   int
+}
+
+func (t promQLToken) String() string {
+	switch t.typ {
+	case promQLEOF:
+		return "end of input"
+	case promQLIdentifier:
+		if promQLKeywords[strings.ToLower(t.value)] {
+			return "keyword \"" + t.value + "\""
+		}
+		return "identifier \"" + t.value + "\""
+	case promQLNumber:
+		return "number \"" + t.value + "\""
+	case promQLDuration:
+		return "duration \"" + t.value + "\""
+	case promQLString:
+		return "string " + t.value
+	}
+	return "\"" + t.value + "\""
+}
+
+// promQLExprKind is the kind of an expression, as far as it decides which modifiers may follow it
+type promQLExprKind int
+
+const (
+	promQLOtherExpr promQLExprKind = iota
+	promQLVectorSelector
+	promQLRangeVectorSelector
+	promQLSubquery
+)
+
+// promQLPunctuations are sorted so that longer operators are matched first
+var promQLPunctuations = []string{"==", "!=", "<=", ">=", "=~", "!~", "(", ")", "{", "}", "[", "]", ",", ":", "@", "+", "-", "*", "/", "%", "^", "<", ">", "="}
+
+// promQLKeywords can not be used as metric names or functions. They can be used as label names
+var promQLKeywords = map[string]bool{
+	"and": true, "or": true, "unless": true, "atan2": true, "by": true, "without": true, "on": true, "ignoring": true,
+	"group_left": true, "group_right": true, "bool": true, "offset": true,
+}
+
+// promQLAggregators are followed by a parenthesized parameter list and optional by or without clauses
+var promQLAggregators = map[string]bool{
+	"sum": true, "avg": true, "count": true, "min": true, "max": true, "group": true, "stddev": true, "stdvar": true,
+	"topk": true, "bottomk": true, "count_values": true, "quantile": true, "limitk": true, "limit_ratio": true,
+}
+
+// binary operators and their precedence, from lowest to highest
+var promQLBinaryOperators = map[string]int{
+	"or": 1, "and": 2, "unless": 2,
+	"==": 3, "!=": 3, "<=": 3, "<": 3, ">=": 3, ">": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5, "atan2": 5,
+	"^": 6,
+}
+
+// promQLBrackets maps the opening brackets to their closing counterparts and their names in error messages
+var promQLBrackets = map[byte]struct {
+	closing byte
+	name    string
+}{
+	'(': {closing: ')', name: "parenthesis"},
+	'[': {closing: ']', name: "bracket"},
+	'{': {closing: '}', name: "brace"},
+}
+
+var promQLComparisonOperators = map[string]bool{"==": true, "!=": true, "<=": true, "<": true, ">=": true, ">": true}
+
+var promQLLabelMatchOperators = map[string]bool{"=": true, "!=": true, "=~": true, "!~": true}
+
+var promQLDurationRegex = regexp.MustCompile(`^(\d+(ms|[smhdwy]))+`)
+var promQLNumberRegex = regexp.MustCompile(`^(0[xX][0-9a-fA-F]+|(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?)`)
+var promQLIdentifierRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_:]*`)
+var promQLWordCharRegex = regexp.MustCompile(`^[a-zA-Z0-9_.]`)
+
+type promQLParser struct {
+	input  string
+	tokens []promQLToken
+	pos    int
+}
+
+func (p *promQLParser) errorAt(pos int, reason string) error {
+	line := 1 + strings.Count(p.input[:pos], "\n")
+	lineStart := strings.LastIndex(p.input[:pos], "\n") + 1
+	return &QueryParseError{
+		Position: pos,
+		Line:     line,
+		Column:   utf8.RuneCountInString(p.input[lineStart:pos]) + 1,
+		Reason:   reason,
+	}
+}
+
+// tokenize splits the query into tokens. Like the PromQL lexer, it reports unbalanced brackets before the query is parsed
+func (p *promQLParser) tokenize() error {
+	// positions of the brackets that have not been closed yet
+	open := []int{}
+	pos := 0
+	for pos < len(p.input) {
+		rest := p.input[pos:]
+		r, size := utf8.DecodeRuneInString(rest)
+
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			pos += size
+			continue
+		case r == '#':
+			// comments last until the end of the line
+			if end := strings.Index(rest, "\n"); end >= 0 {
+				pos += end
+			} else {
+				pos = len(p.input)
+			}
+			continue
+		case r == '"' || r == '\'' || r == '`':
+			length, err := p.scanString(pos, r)
+			if err != nil {
+				return err
+			}
+			p.tokens = append(p.tokens, promQLToken{typ: promQLString, value: rest[:length], pos: pos})
+			pos += length
+			continue
+		}
+
+		if match := promQLDurationRegex.FindString(rest); match != "" && !continuesWord(rest[len(match):]) {
+			p.tokens = append(p.tokens, promQLToken{typ: promQLDuration, value: match, pos: pos})
+			pos += len(match)
+			continue
+		}
+		if match := promQLNumberRegex.FindString(rest); match != "" {
+			if continuesWord(rest[len(match):]) {
+				end := len(match)
+				for continuesWord(rest[end:]) {
+					end++
+				}
+				return p.errorAt(pos, "bad number or duration syntax: \""+rest[:end]+"\"")
+			}
+			p.tokens = append(p.tokens, promQLToken{typ: promQLNumber, value: match, pos: pos})
+			pos += len(match)
+			continue
+		}
+		if match := promQLIdentifierRegex.FindString(rest); match != "" {
+			p.tokens = append(p.tokens, promQLToken{typ: promQLIdentifier, value: match, pos: pos})
+			pos += len(match)
+			continue
+		}
+
+		punctuation := ""
+		for _, candidate := range promQLPunctuations {
+			if strings.HasPrefix(rest, candidate) {
+				punctuation = candidate
+				break
+			}
+		}
+		if punctuation == "" {
+			return p.errorAt(pos, fmt.Sprintf("unexpected character %q", r))
+		}
+		switch punctuation {
+		case "(", "[", "{":
+			open = append(open, pos)
+		case ")", "]", "}":
+			if len(open) == 0 || promQLBrackets[p.input[open[len(open)-1]]].closing != punctuation[0] {
+				return p.errorAt(pos, "unexpected \""+punctuation+"\"")
+			}
+			open = open[:len(open)-1]
+		}
+		p.tokens = append(p.tokens, promQLToken{typ: promQLPunctuation, value: punctuation, pos: pos})
+		pos += len(punctuation)
+	}
+	if len(open) > 0 {
+		pos := open[len(open)-1]
+		return p.errorAt(pos, "unclosed left "+promQLBrackets[p.input[pos]].name)
+	}
+	p.tokens = append(p.tokens, promQLToken{typ: promQLEOF, pos: len(p.input)})
+	return nil
+}
+
+// continuesWord returns whether s starts with a character that would continue a number or duration
+func continuesWord(s string) bool {
+	return s != "" && promQLWordCharRegex.MatchString(s[:1])
+}
+
+// scanString returns the length of the quoted string starting at pos. Like the PromQL lexer, it only accepts the escape
+// sequences of Go strings; raw strings in backticks have none
+func (p *promQLParser) scanString(pos int, quote rune) (int, error) {
+	for i := pos + 1; i < len(p.input); i++ {
+		switch p.input[i] {
+		case byte(quote):
+			return i + 1 - pos, nil
+		case '\\':
+			if quote == '`' {
+				continue
+			}
+			if i+1 == len(p.input) {
+				return 0, p.errorAt(pos, "unterminated quoted string")
+			}
+			_, _, tail, err := strconv.UnquoteChar(p.input[i:], byte(quote))
+			if err != nil {
+				_, size := utf8.DecodeRuneInString(p.input[i+1:])
+				return 0, p.errorAt(i, "invalid escape sequence \""+p.input[i:i+1+size]+"\" in quoted string")
+			}
+			i = len(p.input) - len(tail) - 1
+		case '\n':
+			if quote != '`' {
+				return 0, p.errorAt(pos, "unterminated quoted string")
+			}
+		}
+	}
+	return 0, p.errorAt(pos, "unterminated quoted string")
+}
+
+func (p *promQLParser) peek() promQLToken {
+	return p.tokens[p.pos]
+}
+
+func (p *promQLParser) peekAhead(n int) promQLToken {
+	if p.pos+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *promQLParser) next() promQLToken {
+	token := p.tokens[p.pos]
+	if token.typ != promQLEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *promQLParser) isPunctuation(value string) bool {
+	token := p.peek()
+	return token.typ == promQLPunctuation && token.value == value
+}
+
+func (p *promQLParser) isKeyword(keyword string) bool {
+	token := p.peek()
+	return token.typ == promQLIdentifier && strings.ToLower(token.value) == keyword
+}
+
+func (p *promQLParser) expect(value string, context string) error {
+	if !p.isPunctuation(value) {
+		token := p.peek()
+		return p.errorAt(token.pos, "unexpected "+token.String()+" in "+context+", expected \""+value+"\"")
+	}
+	p.next()
+	return nil
+}
+
+// peekBinaryOperator returns the binary operator at the current position, if any
+func (p *promQLParser) peekBinaryOperator() (string, bool) {
+	token := p.peek()
+	operator := token.value
+	if token.typ == promQLIdentifier {
+		operator = strings.ToLower(operator)
+	} else if token.typ != promQLPunctuation {
+		return "", false
+	}
+	_, ok := promQLBinaryOperators[operator]
+	return operator, ok
+}
+
+func (p *promQLParser) parseExpr(minPrecedence int) (promQLExprKind, error) {
+	kind, err := p.parseUnary()
+	if err != nil {
+		return kind, err
+	}
+	for {
+		operator, ok := p.peekBinaryOperator()
+		if !ok || promQLBinaryOperators[operator] < minPrecedence {
+			return kind, nil
+		}
+		p.next()
+
+		if p.isKeyword("bool") {
+			if !promQLComparisonOperators[operator] {
+				return kind, p.errorAt(p.peek().pos, "bool modifier can only be used on comparison operators")
+			}
+			p.next()
+		}
+		if p.isKeyword("on") || p.isKeyword("ignoring") {
+			p.next()
+			if err := p.parseLabelList("vector matching"); err != nil {
+				return kind, err
+			}
+			if p.isKeyword("group_left") || p.isKeyword("group_right") {
+				p.next()
+				if p.isPunctuation("(") {
+					if err := p.parseLabelList("grouping labels"); err != nil {
+						return kind, err
+					}
+				}
+			}
+		}
+
+		// ^ is right-associative, all other operators are left-associative
+		nextPrecedence := promQLBinaryOperators[operator] + 1
+		if operator == "^" {
+			nextPrecedence = promQLBinaryOperators[operator]
+		}
+		if _, err := p.parseExpr(nextPrecedence); err != nil {
+			return kind, err
+		}
+		kind = promQLOtherExpr
+	}
+}
+
+func (p *promQLParser) parseUnary() (promQLExprKind, error) {
+	if p.isPunctuation("+") || p.isPunctuation("-") {
+		p.next()
+		// the unary operator binds weaker than ^, i.e. -2^2 is -(2^2)
+		if _, err := p.parseExpr(promQLBinaryOperators["^"]); err != nil {
+			return promQLOtherExpr, err
+		}
+		return promQLOtherExpr, nil
+	}
+	kind, err := p.parsePrimary()
+	if err != nil {
+		return kind, err
+	}
+	return p.parsePostfix(kind)
+}
+
+func (p *promQLParser) parsePrimary() (promQLExprKind, error) {
+	token := p.peek()
+	switch token.typ {
+	case promQLNumber, promQLDuration, promQLString:
+		p.next()
+		return promQLOtherExpr, nil
+	case promQLEOF:
+		return promQLOtherExpr, p.errorAt(token.pos, "unexpected end of input")
+	case promQLPunctuation:
+		switch token.value {
+		case "(":
+			p.next()
+			if _, err := p.parseExpr(0); err != nil {
+				return promQLOtherExpr, err
+			}
+			return promQLOtherExpr, p.expect(")", "parenthesized expression")
+		case "{":
+			return promQLVectorSelector, p.parseVectorSelector(false)
+		}
+		return promQLOtherExpr, p.errorAt(token.pos, "unexpected "+token.String())
+	}
+
+	name := strings.ToLower(token.value)
+	next := p.peekAhead(1)
+	switch {
+	case promQLAggregators[name] && (next.typ == promQLPunctuation && next.value == "(" ||
+		next.typ == promQLIdentifier && (strings.ToLower(next.value) == "by" || strings.ToLower(next.value) == "without")):
+		return promQLOtherExpr, p.parseAggregation()
+	case promQLKeywords[name]:
+		return promQLOtherExpr, p.errorAt(token.pos, "unexpected "+token.String())
+	case next.typ == promQLPunctuation && next.value == "(":
+		return promQLOtherExpr, p.parseFunctionCall()
+	case name == "inf" || name == "nan":
+		p.next()
+		return promQLOtherExpr, nil
+	}
+	p.next()
+	if p.isPunctuation("{") {
+		if err := p.parseVectorSelector(true); err != nil {
+			return promQLOtherExpr, err
+		}
+	}
+	return promQLVectorSelector, nil
+}
+
+// parsePostfix parses the ranges, subqueries and offset and @ modifiers that follow an expression
+func (p *promQLParser) parsePostfix(kind promQLExprKind) (promQLExprKind, error) {
+	for {
+		token := p.peek()
+		switch {
+		case p.isPunctuation("["):
+			p.next()
+			if err := p.expectDuration("range"); err != nil {
+				return kind, err
+			}
+			if p.isPunctuation(":") {
+				p.next()
+				if !p.isPunctuation("]") {
+					if err := p.expectDuration("subquery step"); err != nil {
+						return kind, err
+					}
+				}
+				kind = promQLSubquery
+			} else {
+				if kind != promQLVectorSelector {
+					return kind, p.errorAt(token.pos, "ranges only allowed for vector selectors")
+				}
+				kind = promQLRangeVectorSelector
+			}
+			if err := p.expect("]", "range"); err != nil {
+				return kind, err
+			}
+		case p.isKeyword("offset"):
+			if err := p.checkModifierTarget(kind, "offset"); err != nil {
+				return kind, err
+			}
+			p.next()
+			if p.isPunctuation("-") || p.isPunctuation("+") {
+				p.next()
+			}
+			if err := p.expectDuration("offset"); err != nil {
+				return kind, err
+			}
+		case p.isPunctuation("@"):
+			if err := p.checkModifierTarget(kind, "@"); err != nil {
+				return kind, err
+			}
+			p.next()
+			if p.isKeyword("start") || p.isKeyword("end") {
+				p.next()
+				if err := p.expect("(", "@ modifier"); err != nil {
+					return kind, err
+				}
+				if err := p.expect(")", "@ modifier"); err != nil {
+					return kind, err
+				}
+				continue
+			}
+			if p.isPunctuation("-") || p.isPunctuation("+") {
+				p.next()
+			}
+			if next := p.peek(); next.typ != promQLNumber {
+				return kind, p.errorAt(next.pos, "unexpected "+next.String()+" in @ modifier, expected timestamp, start() or end()")
+			}
+			p.next()
+		default:
+			return kind, nil
+		}
+	}
+}
+
+func (p *promQLParser) checkModifierTarget(kind promQLExprKind, modifier string) error {
+	if kind == promQLOtherExpr {
+		return p.errorAt(p.peek().pos, modifier+" modifier must be preceded by a vector selector, range vector selector or subquery")
+	}
+	return nil
+}
+
+// expectDuration accepts durations like 5m or 1h30m and numbers of seconds like 300 or 1.5
+func (p *promQLParser) expectDuration(context string) error {
+	token := p.peek()
+	if token.typ != promQLDuration && token.typ != promQLNumber {
+		return p.errorAt(token.pos, "unexpected "+token.String()+" in "+context+", expected duration")
+	}
+	p.next()
+	return nil
+}
+
+// parseVectorSelector parses the label matchers in braces. Without metric name, a quoted metric name or at least one
+// matcher is required
+func (p *promQLParser) parseVectorSelector(hasMetricName bool) error {
+	start := p.next()
+	matchers := 0
+	for !p.isPunctuation("}") {
+		token := p.peek()
+		switch {
+		case token.typ == promQLString && (p.peekAhead(1).value == "," || p.peekAhead(1).value == "}"):
+			// a quoted metric name, e.g. {"my.metric"}
+			p.next()
+		case token.typ == promQLIdentifier || token.typ == promQLString:
+			p.next()
+			operator := p.peek()
+			if operator.typ != promQLPunctuation || !promQLLabelMatchOperators[operator.value] {
+				return p.errorAt(operator.pos, "unexpected "+operator.String()+" in label matching, expected label matching operator \"=\", \"!=\", \"=~\" or \"!~\"")
+			}
+			p.next()
+			if value := p.peek(); value.typ != promQLString {
+				return p.errorAt(value.pos, "unexpected "+value.String()+" in label matching, expected string")
+			}
+			p.next()
+		default:
+			return p.errorAt(token.pos, "unexpected "+token.String()+" in label matching, expected label name or \"}\"")
+		}
+		matchers++
+
+		if p.isPunctuation(",") {
+			p.next()
+		} else if !p.isPunctuation("}") {
+			token := p.peek()
+			return p.errorAt(token.pos, "unexpected "+token.String()+" in label matching, expected \",\" or \"}\"")
+		}
+	}
+	p.next()
+	if !hasMetricName && matchers == 0 {
+		return p.errorAt(start.pos, "vector selector must contain at least one matcher")
+	}
+	return nil
+}
+
+// parseLabelList parses a parenthesized, comma-separated list of label names, which may be quoted
+func (p *promQLParser) parseLabelList(context string) error {
+	if err := p.expect("(", context); err != nil {
+		return err
+	}
+	for !p.isPunctuation(")") {
+		if token := p.peek(); token.typ != promQLIdentifier && token.typ != promQLString {
+			return p.errorAt(token.pos, "unexpected "+token.String()+" in "+context+", expected label name")
+		}
+		p.next()
+		if p.isPunctuation(",") {
+			p.next()
+		} else if !p.isPunctuation(")") {
+			token := p.peek()
+			return p.errorAt(token.pos, "unexpected "+token.String()+" in "+context+", expected \",\" or \")\"")
+		}
+	}
+	p.next()
+	return nil
+}
+
+// parseAggregation parses e.g. sum by (job) (rate(x[5m])) or topk(3, x) without (instance)
+func (p *promQLParser) parseAggregation() error {
+	name := p.next().value
+	hasGrouping := false
+	if p.isKeyword("by") || p.isKeyword("without") {
+		p.next()
+		if err := p.parseLabelList("grouping opts"); err != nil {
+			return err
+		}
+		hasGrouping = true
+	}
+	if err := p.parseArguments("aggregation " + name); err != nil {
+		return err
+	}
+	if !hasGrouping && (p.isKeyword("by") || p.isKeyword("without")) {
+		p.next()
+		return p.parseLabelList("grouping opts")
+	}
+	return nil
+}
+
+func (p *promQLParser) parseFunctionCall() error {
+	name := p.next().value
+	return p.parseArguments("call to function " + name)
+}
+
+func (p *promQLParser) parseArguments(context string) error {
+	if err := p.expect("(", context); err != nil {
+		return err
+	}
+	if p.isPunctuation(")") {
+		p.next()
+		return nil
+	}
+	for {
+		if _, err := p.parseExpr(0); err != nil {
+			return err
+		}
+		if p.isPunctuation(")") {
+			p.next()
+			return nil
+		}
+		if !p.isPunctuation(",") {
+			token := p.peek()
+			return p.errorAt(token.pos, "unexpected "+token.String()+" in "+context+", expected \",\" or \")\"")
+		}
+		p.next()
+	}
+}
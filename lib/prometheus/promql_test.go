@@ -0,0 +1,130 @@
+package prometheus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateQueryWithValidQueries(t *testing.T) {
+	tests := []string{
+		"up",
+		"1",
+		"-2^2",
+		"0x1F + 1e3 - .5",
+		"Inf > NaN",
+		`"some string"`,
+		"sum(rate(http_requests_total{job='carts-sockshop-dev-canary',status!~'2..'}[30s]))/sum(rate(http_requests_total{job='carts-sockshop-dev-canary'}[30s]))",
+		"histogram_quantile(0.95, sum by(le) (rate(http_response_time_milliseconds_bucket{handler=\"ItemsController.addToCart\",job=\"carts-sockshop-dev-canary\"}[5m])))",
+		"histogram_quantile(0.95,sum(rate(http_response_time_milliseconds_bucket{job='carts-sockshop-dev-canary'}[1s]))by(le))",
+		"avg(rate(container_cpu_usage_seconds_total{namespace=\"sockshop-dev\",pod_name=~\"carts-primary-.*\"}[5m]))",
+		"my_custom_query{job='carts-sockshop-dev'}[1s]",
+		"topk(3, sum without (instance) (rate(http_requests_total[5m])))",
+		"count_values(\"version\", build_info)",
+		"quantile_over_time(0.9, http_request_duration_seconds[10m])",
+		"max_over_time(rate(http_requests_total[1m])[30m:1m])",
+		"rate(http_requests_total[5m] offset 1h)",
+		"http_requests_total @ 1609746000 offset -5m",
+		"rate(http_requests_total[5m] @ end())",
+		"sum(rate(errors_total[5m])) / on(job) group_left(team) sum(rate(requests_total[5m]))",
+		"http_requests_total > bool 100",
+		"up == 1 and on(instance) node_load1 > 2 or vector(0)",
+		"{__name__=~\"http_.*\",job!=\"\"}",
+		"label_replace(up, \"host\", \"$1\", \"instance\", \"(.*):.*\")",
+		"time() - process_start_time_seconds # uptime\n",
+		"sum(up{job=\"a\",})",
+		"job:http_requests:rate5m{job=\"carts\"}",
+		"sort_desc(sum by (handler) (rate(http_requests_total{handler!~`Health.*`}[1h30m])))",
+		"info(up)",
+		`info(rate(http_requests_total[5m]), {k8s_cluster_name=~".+"})`,
+		"first_over_time(up[5m])",
+		// functions of newer Prometheus versions, Thanos or VictoriaMetrics are not known to the validator
+		"rates(http_requests_total[5m])",
+		"rollup_rate(http_requests_total[5m], \"max\")",
+		`{"my.metric"}`,
+		`{"my.metric", job="carts"}`,
+		`up{name="O\"Brien",team='O\'Brien'}`,
+		`up{name="O'Brien",team='say "hi"'}`,
+		`up{path="\x41\u00e9\U0001F600\101\a\b\f\n\r\t\v\\"}`,
+		`up{handler=~"Items\\.add.*"}`,
+		"up{handler=~`Items\\.add.*`}",
+		`sum by ("service.name") (rate({"http.server.requests", "service.name"="carts"}[5m]))`,
+		"rate(http_requests_total[300])",
+		"http_requests_total offset 300",
+		"max_over_time(rate(http_requests_total[5m])[1h:30])",
+		// type errors and wrong numbers of arguments are left to Prometheus
+		"rate(http_requests_total)",
+		"histogram_quantile(sum(rate(x[5m])) by (le))",
+		"sum(up)",
+		"count",
+		"rate(up[5m])[1h:]",
+		"a ^ b ^ -c",
+		"up unless ignoring(instance) group_right up",
+	}
+	for _, test := range tests {
+		assert.Nil(t, ValidateQuery(test), test)
+	}
+}
+
+func TestValidateQueryWithInvalidQueries(t *testing.T) {
+	tests := []struct {
+		query  string
+		column int
+		reason string
+	}{
+		{"", 1, "no expression found in input"},
+		{"# only a comment\n", 1, "no expression found in input"},
+		{"sum(rate(http_requests_total[5m])", 4, "unclosed left parenthesis"},
+		{"rate(http_requests_total{job='carts'[5m])", 41, `unexpected ")"`},
+		{"http_requests_total{job='carts'", 20, "unclosed left brace"},
+		{"rate(http_requests_total[5m)", 28, `unexpected ")"`},
+		{`up{name="O\'Brien"}`, 11, `invalid escape sequence "\'" in quoted string`},
+		{`up{name='O\"Brien'}`, 11, `invalid escape sequence "\"" in quoted string`},
+		{`up{handler=~"Items\.add"}`, 19, `invalid escape sequence "\." in quoted string`},
+		{`up{path="\x4"}`, 10, `invalid escape sequence "\x" in quoted string`},
+		{`up{path="\9"}`, 10, `invalid escape sequence "\9" in quoted string`},
+		{"up{job='carts-$SERVICE_NAME'}", 0, ""},
+		{"up{job='carts'} $SERVICE_NAME", 17, `unexpected character '$'`},
+		{"up{job='carts}", 8, "unterminated quoted string"},
+		{"up\n  and )", 7, `unexpected ")"`},
+		{"foo bar", 5, `unexpected identifier "bar"`},
+		{"1 +* 2", 4, `unexpected "*"`},
+		{"up{job=}", 8, `unexpected "}" in label matching, expected string`},
+		{"up{job}", 7, `unexpected "}" in label matching, expected label matching operator "=", "!=", "=~" or "!~"`},
+		{"up{job='a' team='b'}", 12, `unexpected identifier "team" in label matching, expected "," or "}"`},
+		{"rate(x[5m]) +", 14, "unexpected end of input"},
+		{"sum by rate(x)", 8, `unexpected identifier "rate" in grouping opts, expected "("`},
+		{"sum(x) by", 10, `unexpected end of input in grouping opts, expected "("`},
+		{"rate(http_requests_total[5min])", 26, `bad number or duration syntax: "5min"`},
+		{"rate(x[])", 8, `unexpected "]" in range, expected duration`},
+		{"sum(rate(x[5m]))[5m]", 17, "ranges only allowed for vector selectors"},
+		{"sum(up) offset 5m", 9, "offset modifier must be preceded by a vector selector, range vector selector or subquery"},
+		{"up @ foo", 6, `unexpected identifier "foo" in @ modifier, expected timestamp, start() or end()`},
+		{"up + bool up", 6, "bool modifier can only be used on comparison operators"},
+		{"offset(up)", 1, `unexpected keyword "offset"`},
+		{"{}", 1, "vector selector must contain at least one matcher"},
+		{"max(a b)", 7, `unexpected identifier "b" in aggregation max, expected "," or ")"`},
+		{"up / on job up", 9, `unexpected identifier "job" in vector matching, expected "("`},
+	}
+	for _, test := range tests {
+		err := ValidateQuery(test.query)
+		if test.reason == "" {
+			assert.Nil(t, err, test.query)
+			continue
+		}
+		if assert.IsType(t, &QueryParseError{}, err, test.query) {
+			parseErr := err.(*QueryParseError)
+			assert.EqualValues(t, test.reason, parseErr.Reason, test.query)
+			assert.EqualValues(t, test.column, parseErr.Column, test.query)
+		}
+	}
+}
+
+func TestQueryParseErrorPosition(t *testing.T) {
+	err := ValidateQuery("sum(\n  rate(x[5m]) +\n  ]")
+
+	assert.EqualValues(t, `line 3, column 3: unexpected "]"`, err.Error())
+
+	err = ValidateQuery("sum(\n  rate(x[5m]) +\n)")
+
+	assert.EqualValues(t, `line 3, column 1: unexpected ")"`, err.Error())
+}
@@ -0,0 +1,122 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
+)
+
+// healthCheckInterval is the time the health of a replica is remembered before it is checked again
+const healthCheckInterval = 30 * time.Second
+
+// healthCheckTimeout limits the time a health check may take
+const healthCheckTimeout = 5 * time.Second
+
+type replicaState struct {
+	healthy   bool
+	checkedAt time.Time
+}
+
+// replicaStates remembers the health of the replicas across events, so a replica that is down is not checked again by
+// every query
+var replicaStates = struct {
+	sync.Mutex
+	states map[string]replicaState
+}{states: map[string]replicaState{}}
+
+// sendToReplicas sends the query to ApiURL and fails over to the next replica on connection errors and 5xx responses.
+// It returns the response of the first replica that did not fail, or of the last replica, and whether the query failed
+// transiently. Requests that can not be created, e.g. because of missing credentials, do not fail transiently
+func (ph *Handler) sendToReplicas(ctx context.Context, queryPath string, logger keptncommon.LoggerInterface) (int, []byte, bool, error) {
+	replicaURLs := ph.getOrderedReplicaURLs(ctx, logger)
+	for i, replicaURL := range replicaURLs {
+		req, err := ph.newRequest(ctx, replicaURL+queryPath)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		statusCode, body, err := ph.sendRequest(req)
+		if !isReplicaFailure(statusCode, err) {
+			ph.setReplicaHealth(replicaURL, true)
+			return statusCode, body, isTransientFailure(statusCode, err), err
+		}
+		ph.setReplicaHealth(replicaURL, false)
+		if i == len(replicaURLs)-1 || ctx.Err() != nil {
+			return statusCode, body, isTransientFailure(statusCode, err), err
+		}
+
+		reason := "status " + strconv.Itoa(statusCode)
+		if err != nil {
+			reason = err.Error()
+		}
+		logger.Info("Prometheus replica " + RedactURLCredentials(replicaURL) + " failed (" + reason + "), failing over to " + RedactURLCredentials(replicaURLs[i+1]))
+	}
+	return 0, nil, false, nil
+}
+
+// isReplicaFailure returns true if the replica could not answer the query, but another replica might
+func isReplicaFailure(statusCode int, err error) bool {
+	return err != nil || statusCode >= http.StatusInternalServerError
+}
+
+// getOrderedReplicaURLs returns ApiURL and the replicas, with healthy replicas first if a health check is configured.
+// Unhealthy replicas are still queried last, in case all of them are reported as unhealthy
+func (ph *Handler) getOrderedReplicaURLs(ctx context.Context, logger keptncommon.LoggerInterface) []string {
+	replicaURLs := append([]string{ph.ApiURL}, ph.ReplicaURLs...)
+	if len(replicaURLs) == 1 || ph.HealthCheckPath == "" {
+		return replicaURLs
+	}
+
+	healthy := []string{}
+	unhealthy := []string{}
+	for _, replicaURL := range replicaURLs {
+		if ph.isReplicaHealthy(ctx, replicaURL, logger) {
+			healthy = append(healthy, replicaURL)
+		} else {
+			unhealthy = append(unhealthy, replicaURL)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// isReplicaHealthy returns the remembered health of a replica, or checks it with the health check path
+func (ph *Handler) isReplicaHealthy(ctx context.Context, replicaURL string, logger keptncommon.LoggerInterface) bool {
+	replicaStates.Lock()
+	state, ok := replicaStates.states[replicaURL]
+	replicaStates.Unlock()
+	if ok && time.Since(state.checkedAt) < healthCheckInterval {
+		return state.healthy
+	}
+
+	healthy := ph.checkReplicaHealth(ctx, replicaURL)
+	if !healthy {
+		logger.Info("Prometheus replica " + RedactURLCredentials(replicaURL) + " is not healthy, it is queried last")
+	}
+	ph.setReplicaHealth(replicaURL, healthy)
+	return healthy
+}
+
+func (ph *Handler) checkReplicaHealth(ctx context.Context, replicaURL string) bool {
+	checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	req, err := ph.newRequest(checkCtx, replicaURL+ph.HealthCheckPath)
+	if err != nil {
+		return false
+	}
+	statusCode, _, err := ph.sendRequest(req)
+	return err == nil && statusCode >= 200 && statusCode < 300
+}
+
+// setReplicaHealth remembers the health of a replica as observed by a health check or a query
+func (ph *Handler) setReplicaHealth(replicaURL string, healthy bool) {
+	if ph.HealthCheckPath == "" {
+		return
+	}
+	replicaStates.Lock()
+	defer replicaStates.Unlock()
+	replicaStates.states[replicaURL] = replicaState{healthy: healthy, checkedAt: time.Now()}
+}
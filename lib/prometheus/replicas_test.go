@@ -0,0 +1,196 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
+	"github.com/stretchr/testify/assert"
+)
+
+// replicaRequests records the requests per replica, i.e. per host of the request
+type replicaRequests struct {
+	sync.Mutex
+	paths map[string][]string
+}
+
+func (r *replicaRequests) add(host string, path string) {
+	r.Lock()
+	defer r.Unlock()
+	r.paths[host] = append(r.paths[host], path)
+}
+
+func (r *replicaRequests) get(host string) []string {
+	r.Lock()
+	defer r.Unlock()
+	return r.paths[host]
+}
+
+func resetReplicaStates() {
+	replicaStates.Lock()
+	defer replicaStates.Unlock()
+	replicaStates.states = map[string]replicaState{}
+}
+
+func TestGetSLIResultsWithFailover(t *testing.T) {
+	requests := &replicaRequests{paths: map[string][]string{}}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.add(r.Host, r.URL.Path)
+		if r.Host == "prometheus-0" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1571649085,"0.2"]}]}}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus-0", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.ReplicaURLs = []string{"http://prometheus-1", "http://prometheus-2"}
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	results, err := ph.GetSLIResults(context.Background(), Throughput, start, end, keptncommon.NewLogger("", "", ""))
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(results))
+	assert.EqualValues(t, 0.2, results[0].Value)
+	assert.EqualValues(t, "", results[0].Message)
+	assert.EqualValues(t, []string{"/api/v1/query"}, requests.get("prometheus-0"))
+	assert.EqualValues(t, []string{"/api/v1/query"}, requests.get("prometheus-1"))
+	assert.Empty(t, requests.get("prometheus-2"))
+}
+
+func TestGetSLIResultsWithFailoverOnConnectionError(t *testing.T) {
+	unavailable := httptest.NewServer(http.NotFoundHandler())
+	unavailable.Close()
+	available := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1571649085,"0.2"]}]}}`))
+	}))
+	defer available.Close()
+
+	ph := NewPrometheusHandler(unavailable.URL, "sockshop", "dev", "carts", nil)
+	ph.ReplicaURLs = []string{available.URL}
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	value, err := ph.GetSLIValue(context.Background(), Throughput, start, end, keptncommon.NewLogger("", "", ""))
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0.2, value)
+}
+
+func TestGetSLIValueWithoutFailoverOnBadRequest(t *testing.T) {
+	requests := &replicaRequests{paths: map[string][]string{}}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.add(r.Host, r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus-0", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.ReplicaURLs = []string{"http://prometheus-1"}
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	_, err := ph.GetSLIValue(context.Background(), Throughput, start, end, keptncommon.NewLogger("", "", ""))
+
+	// an invalid query fails on every replica
+	assert.NotNil(t, err)
+	assert.EqualValues(t, 1, len(requests.get("prometheus-0")))
+	assert.Empty(t, requests.get("prometheus-1"))
+}
+
+func TestGetSLIValueWithAllReplicasFailing(t *testing.T) {
+	requests := &replicaRequests{paths: map[string][]string{}}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.add(r.Host, r.URL.Path)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus-0", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.ReplicaURLs = []string{"http://prometheus-1"}
+	ph.MaxAttempts = 2
+	ph.RetryBackoff = time.Millisecond
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	_, err := ph.GetSLIValue(context.Background(), Throughput, start, end, keptncommon.NewLogger("", "", ""))
+
+	// each attempt fails over to all replicas
+	assert.NotNil(t, err)
+	assert.EqualValues(t, 2, len(requests.get("prometheus-0")))
+	assert.EqualValues(t, 2, len(requests.get("prometheus-1")))
+}
+
+func TestGetSLIValueWithHealthCheck(t *testing.T) {
+	resetReplicaStates()
+	defer resetReplicaStates()
+
+	requests := &replicaRequests{paths: map[string][]string{}}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.add(r.Host, r.URL.Path)
+		if r.URL.Path == "/-/ready" {
+			if r.Host == "prometheus-0" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1571649085,"0.2"]}]}}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus-0", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.ReplicaURLs = []string{"http://prometheus-1"}
+	ph.HealthCheckPath = "/-/ready"
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	for i := 0; i < 2; i++ {
+		value, err := ph.GetSLIValue(context.Background(), Throughput, start, end, logger)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 0.2, value)
+	}
+
+	// the unhealthy replica is not queried, and the health is checked only once within the interval
+	assert.EqualValues(t, []string{"/-/ready"}, requests.get("prometheus-0"))
+	assert.EqualValues(t, []string{"/-/ready", "/api/v1/query", "/api/v1/query"}, requests.get("prometheus-1"))
+}
+
+func TestGetOrderedReplicaURLs(t *testing.T) {
+	resetReplicaStates()
+	defer resetReplicaStates()
+
+	ph := NewPrometheusHandler("http://prometheus-0", "sockshop", "dev", "carts", nil)
+	ph.ReplicaURLs = []string{"http://prometheus-1", "http://prometheus-2"}
+	logger := keptncommon.NewLogger("", "", "")
+
+	// without health check, the configured order is kept even if a replica failed
+	ph.setReplicaHealth("http://prometheus-0", false)
+	assert.EqualValues(t, []string{"http://prometheus-0", "http://prometheus-1", "http://prometheus-2"}, ph.getOrderedReplicaURLs(context.Background(), logger))
+
+	ph.HealthCheckPath = "/-/ready"
+	ph.setReplicaHealth("http://prometheus-0", false)
+	ph.setReplicaHealth("http://prometheus-1", true)
+	ph.setReplicaHealth("http://prometheus-2", true)
+	assert.EqualValues(t, []string{"http://prometheus-1", "http://prometheus-2", "http://prometheus-0"}, ph.getOrderedReplicaURLs(context.Background(), logger))
+}
@@ -0,0 +1,250 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
+)
+
+// maxErrorBodyLength limits how much of a response body that is not a Prometheus API response ends up in an error
+const maxErrorBodyLength = 200
+
+// prometheusResponse is the format of all responses of the Prometheus HTTP API, see
+// https://prometheus.io/docs/prometheus/latest/querying/api/#format-overview
+type prometheusResponse struct {
+	Status    string   `json:"status"`
+	ErrorType string   `json:"errorType"`
+	Error     string   `json:"error"`
+	Warnings  []string `json:"warnings"`
+	Data      struct {
+		ResultType string `json:"resultType"`
+		// Result is decoded depending on the ResultType
+		Result json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+const resultTypeVector = "vector"
+const resultTypeMatrix = "matrix"
+const resultTypeScalar = "scalar"
+const resultTypeString = "string"
+
+// vectorResult is the result of an instant query returning an instant vector
+type vectorResult []struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+}
+
+// matrixResult is the result of a range query, or of an instant query returning a range vector
+type matrixResult []struct {
+	Metric map[string]string `json:"metric"`
+	Values [][]interface{}   `json:"values"`
+}
+
+// getSeriesValues maps the result to one SLI value per series:
+//   - vector: the value of each series
+//   - matrix: the samples of each series reduced with the configured aggregation
+//   - scalar: the value, as a single series without labels
+//   - string: the string parsed as number, as a single series without labels
+func (r *prometheusResponse) getSeriesValues(options *indicatorOptions) ([]seriesValue, error) {
+	values := []seriesValue{}
+	if len(r.Data.Result) == 0 {
+		return values, nil
+	}
+
+	switch r.Data.ResultType {
+	case resultTypeVector:
+		result := vectorResult{}
+		if err := json.Unmarshal(r.Data.Result, &result); err != nil {
+			return nil, errors.New("could not parse vector result: " + err.Error())
+		}
+		for _, series := range result {
+			if len(series.Value) < 2 {
+				continue
+			}
+			value, err := parseSampleValue(series.Value)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, seriesValue{Labels: series.Metric, Value: value})
+		}
+	case resultTypeMatrix:
+		result := matrixResult{}
+		if err := json.Unmarshal(r.Data.Result, &result); err != nil {
+			return nil, errors.New("could not parse matrix result: " + err.Error())
+		}
+		for _, series := range result {
+			if len(series.Values) == 0 {
+				continue
+			}
+			value, err := getRangeValue(series.Values, options)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, seriesValue{Labels: series.Metric, Value: value})
+		}
+	case resultTypeScalar, resultTypeString:
+		sample := []interface{}{}
+		if err := json.Unmarshal(r.Data.Result, &sample); err != nil {
+			return nil, errors.New("could not parse " + r.Data.ResultType + " result: " + err.Error())
+		}
+		if len(sample) < 2 {
+			return values, nil
+		}
+		value, err := parseSampleValue(sample)
+		if err != nil {
+			if r.Data.ResultType == resultTypeString {
+				return nil, fmt.Errorf("query returned the string %q, which can not be converted to an SLI value", fmt.Sprintf("%v", sample[1]))
+			}
+			return nil, err
+		}
+		values = append(values, seriesValue{Value: value})
+	default:
+		return nil, errors.New("unsupported result type \"" + r.Data.ResultType + "\"")
+	}
+	return values, nil
+}
+
+// APIError is returned if Prometheus could not execute a query, e.g. because of a bad_data error or a timeout
+type APIError struct {
+	StatusCode int
+	// ErrorType is the errorType of the Prometheus response, e.g. bad_data, timeout or execution
+	ErrorType string
+	Message   string
+	Warnings  []string
+}
+
+func (e *APIError) Error() string {
+	message := "Prometheus query failed with status " + strconv.Itoa(e.StatusCode)
+	if e.ErrorType != "" {
+		message += " (" + e.ErrorType + ")"
+	}
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	if len(e.Warnings) > 0 {
+		message += "; warnings: " + strings.Join(e.Warnings, "; ")
+	}
+	return message
+}
+
+// parsePrometheusResponse decodes the body of a Prometheus API response and returns an APIError if the query failed
+func parsePrometheusResponse(statusCode int, body []byte) (*prometheusResponse, error) {
+	prometheusResult := &prometheusResponse{}
+	err := json.Unmarshal(body, prometheusResult)
+
+	if statusCode != http.StatusOK || (err == nil && prometheusResult.Status == "error") {
+		if err != nil || prometheusResult.Status != "error" {
+			// not a Prometheus API response, e.g. the error page of a proxy
+			return nil, &APIError{StatusCode: statusCode, Message: getErrorBody(statusCode, body)}
+		}
+		return nil, &APIError{
+			StatusCode: statusCode,
+			ErrorType:  prometheusResult.ErrorType,
+			Message:    prometheusResult.Error,
+			Warnings:   prometheusResult.Warnings,
+		}
+	}
+	if err != nil {
+		return nil, errors.New("could not parse Prometheus response: " + err.Error())
+	}
+	return prometheusResult, nil
+}
+
+func getErrorBody(statusCode int, body []byte) string {
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		return http.StatusText(statusCode)
+	}
+	if len(message) > maxErrorBodyLength {
+		message = message[:maxErrorBodyLength] + "..."
+	}
+	return message
+}
+
+// seriesValue is the value of a single series of a query result
+type seriesValue struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// queryResult contains the values of all series returned for a query, and the warnings Prometheus returned with them
+type queryResult struct {
+	Values   []seriesValue
+	Warnings []string
+	// Retries is the number of times the query has been repeated after a transient failure
+	Retries int
+	// Partial is true if the result may lack the data of unavailable stores
+	Partial bool
+}
+
+// getSingleValue returns the value of a query that is expected to return at most one series. If the query returned no
+// data, the value and message are determined by the empty result policy
+func (r *queryResult) getSingleValue(policy EmptyResultPolicy, logger keptncommon.LoggerInterface) (float64, string, error) {
+	if len(r.Values) == 0 {
+		value, message, err := policy.apply()
+		if err != nil {
+			return 0, "", err
+		}
+		logger.Info("Prometheus Result is empty: " + message)
+		return value, message, nil
+	}
+	if len(r.Values) > 1 {
+		return 0, "", fmt.Errorf("query returned %d series instead of one; aggregate the query (e.g. with sum) or set %s=true to report each series", len(r.Values), optionBreakdown)
+	}
+
+	logger.Info(fmt.Sprintf("Prometheus Result is %v", r.Values[0].Value))
+	return r.Values[0].Value, "", nil
+}
+
+// getWarningsMessage returns the warnings of the query result as message that is reported with the SLI results
+func (r *queryResult) getWarningsMessage() string {
+	if len(r.Warnings) == 0 {
+		return ""
+	}
+	message := "Prometheus warnings: " + strings.Join(r.Warnings, "; ")
+	if r.Partial {
+		message = "partial response, the result may be incomplete; " + message
+	}
+	return message
+}
+
+// joinMessages joins all non-empty messages
+func joinMessages(messages ...string) string {
+	nonEmpty := []string{}
+	for _, message := range messages {
+		if message != "" {
+			nonEmpty = append(nonEmpty, message)
+		}
+	}
+	return strings.Join(nonEmpty, "; ")
+}
+
+// getRangeValue reduces the samples of a range query series to a single value using the configured aggregation
+func getRangeValue(values [][]interface{}, options *indicatorOptions) (float64, error) {
+	samples := []float64{}
+	for _, sample := range values {
+		if len(sample) < 2 {
+			continue
+		}
+		floatValue, err := parseSampleValue(sample)
+		if err != nil {
+			return 0, err
+		}
+		samples = append(samples, floatValue)
+	}
+	return aggregate(samples, options.Aggregation)
+}
+
+// parseSampleValue parses a [<timestamp>, "<value>"] sample
+func parseSampleValue(sample []interface{}) (float64, error) {
+	floatValue, err := strconv.ParseFloat(fmt.Sprintf("%v", sample[1]), 64)
+	if err != nil {
+		return 0, errors.New("could not parse sample value: " + err.Error())
+	}
+	return floatValue, nil
+}
@@ -0,0 +1,109 @@
+package prometheus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParsePrometheusResponseWithBadData(t *testing.T) {
+	body := `{"status":"error","errorType":"bad_data","error":"invalid parameter \"query\": 1:5: parse error: unexpected \"}\""}`
+
+	_, err := parsePrometheusResponse(http.StatusBadRequest, []byte(body))
+
+	if assert.IsType(t, &APIError{}, err) {
+		apiErr := err.(*APIError)
+		assert.EqualValues(t, http.StatusBadRequest, apiErr.StatusCode)
+		assert.EqualValues(t, "bad_data", apiErr.ErrorType)
+	}
+	assert.EqualValues(t, `Prometheus query failed with status 400 (bad_data): invalid parameter "query": 1:5: parse error: unexpected "}"`, err.Error())
+}
+
+func TestParsePrometheusResponseWithTimeout(t *testing.T) {
+	body := `{"status":"error","errorType":"timeout","error":"query timed out in expression evaluation","warnings":["some warning"]}`
+
+	_, err := parsePrometheusResponse(http.StatusServiceUnavailable, []byte(body))
+
+	assert.EqualValues(t, "Prometheus query failed with status 503 (timeout): query timed out in expression evaluation; warnings: some warning", err.Error())
+}
+
+func TestParsePrometheusResponseWithoutAPIResponse(t *testing.T) {
+	_, err := parsePrometheusResponse(http.StatusUnauthorized, []byte(""))
+	assert.EqualValues(t, "Prometheus query failed with status 401: Unauthorized", err.Error())
+
+	_, err = parsePrometheusResponse(http.StatusBadGateway, []byte("<html>"+strings.Repeat("a", 300)+"</html>"))
+	assert.EqualValues(t, "Prometheus query failed with status 502: <html>"+strings.Repeat("a", 194)+"...", err.Error())
+}
+
+func TestParsePrometheusResponseWithErrorStatus(t *testing.T) {
+	_, err := parsePrometheusResponse(http.StatusOK, []byte(`{"status":"error","errorType":"execution","error":"expanding series: context canceled"}`))
+
+	assert.EqualValues(t, "Prometheus query failed with status 200 (execution): expanding series: context canceled", err.Error())
+}
+
+func TestParsePrometheusResponseWithWarnings(t *testing.T) {
+	body := `{"status":"success","warnings":["PromQL info: metric might not be a counter"],"data":{"resultType":"vector","result":[]}}`
+
+	prometheusResult, err := parsePrometheusResponse(http.StatusOK, []byte(body))
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"PromQL info: metric might not be a counter"}, prometheusResult.Warnings)
+}
+
+func TestGetSeriesValuesWithResultTypes(t *testing.T) {
+	options := &indicatorOptions{Mode: InstantMode, Aggregation: AggregationMax}
+
+	tests := []struct {
+		body string
+		want []seriesValue
+	}{
+		{
+			body: `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"handler":"a"},"value":[1571649085,"1.5"]}]}}`,
+			want: []seriesValue{{Labels: map[string]string{"handler": "a"}, Value: 1.5}},
+		},
+		{
+			body: `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1571649025,"1"],[1571649055,"3"],[1571649085,"2"]]}]}}`,
+			want: []seriesValue{{Labels: map[string]string{}, Value: 3}},
+		},
+		{
+			body: `{"status":"success","data":{"resultType":"scalar","result":[1571649085,"42"]}}`,
+			want: []seriesValue{{Value: 42}},
+		},
+		{
+			body: `{"status":"success","data":{"resultType":"string","result":[1571649085,"0.25"]}}`,
+			want: []seriesValue{{Value: 0.25}},
+		},
+		{
+			body: `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			want: []seriesValue{},
+		},
+	}
+	for _, test := range tests {
+		prometheusResult, err := parsePrometheusResponse(http.StatusOK, []byte(test.body))
+		assert.Nil(t, err, test.body)
+
+		values, err := prometheusResult.getSeriesValues(options)
+		assert.Nil(t, err, test.body)
+		assert.EqualValues(t, test.want, values, test.body)
+	}
+}
+
+func TestGetSeriesValuesWithUnsupportedResults(t *testing.T) {
+	options := &indicatorOptions{Mode: InstantMode, Aggregation: AggregationAvg}
+
+	tests := map[string]string{
+		`{"status":"success","data":{"resultType":"string","result":[1571649085,"carts"]}}`: `query returned the string "carts", which can not be converted to an SLI value`,
+		`{"status":"success","data":{"resultType":"histogram","result":[1571649085,"1"]}}`:  `unsupported result type "histogram"`,
+		`{"status":"success","data":{"resultType":"scalar","result":{"value":"1"}}}`:        "could not parse scalar result: json: cannot unmarshal object into Go value of type []interface {}",
+	}
+	for body, want := range tests {
+		prometheusResult, err := parsePrometheusResponse(http.StatusOK, []byte(body))
+		assert.Nil(t, err, body)
+
+		_, err = prometheusResult.getSeriesValues(options)
+		if assert.NotNil(t, err, body) {
+			assert.EqualValues(t, want, err.Error(), body)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
+)
+
+// DefaultMaxAttempts is the number of times a query is sent unless the handler defines another number
+const DefaultMaxAttempts = 3
+
+// DefaultRetryBackoff is the delay before the first retry unless the handler defines another delay
+const DefaultRetryBackoff = time.Second
+
+// maxRetryBackoff limits the delay between two attempts
+const maxRetryBackoff = 30 * time.Second
+
+// retryableStatusCodes are returned by Prometheus or a proxy in front of it for failures that are usually transient
+var retryableStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// sendQuery sends a GET request to the Prometheus API, fails over to the replicas of the handler and retries it with
+// exponential backoff if all of them failed transiently. It returns the status code and body of the last attempt and the
+// number of retries
+func (ph *Handler) sendQuery(ctx context.Context, queryPath string, logger keptncommon.LoggerInterface) (int, []byte, int, error) {
+	maxAttempts := ph.getMaxAttempts()
+	for attempt := 1; ; attempt++ {
+		statusCode, body, transient, err := ph.sendToReplicas(ctx, queryPath, logger)
+		if attempt >= maxAttempts || ctx.Err() != nil || !transient {
+			return statusCode, body, attempt - 1, err
+		}
+
+		delay := ph.getRetryDelay(attempt)
+		reason := "status " + strconv.Itoa(statusCode)
+		if err != nil {
+			reason = err.Error()
+		}
+		logger.Info(fmt.Sprintf("Prometheus query failed (%s), retrying in %v (attempt %d of %d)", reason, delay, attempt+1, maxAttempts))
+
+		select {
+		case <-ctx.Done():
+			return statusCode, body, attempt - 1, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// newRequest creates a GET request with the headers and the authentication of the handler. A SigV4 signature is only
+// valid for a few minutes, so a request is created for each attempt
+func (ph *Handler) newRequest(ctx context.Context, requestURL string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if err := ph.setRequestHeaders(req); err != nil {
+		return nil, err
+	}
+	if ph.SigV4 != nil {
+		if err := ph.signSigV4(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+func (ph *Handler) sendRequest(req *http.Request) (int, []byte, error) {
+	resp, err := ph.HTTPClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+// isTransientFailure returns true for transient connection failures and for status codes that indicate an overloaded or
+// temporarily unavailable Prometheus. Queries are read-only, so they can be repeated safely
+func isTransientFailure(statusCode int, err error) bool {
+	if err != nil {
+		return isTransientError(err)
+	}
+	return retryableStatusCodes[statusCode]
+}
+
+// isTransientError returns true for timeouts, refused and reset connections and connections that were closed before
+// the response was complete. Other errors, like failed certificate verification, unsupported schemes or unknown hosts,
+// do not go away when the query is repeated
+func isTransientError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (ph *Handler) getMaxAttempts() int {
+	if ph.MaxAttempts > 0 {
+		return ph.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+// getRetryDelay doubles the backoff with each attempt and randomizes it by up to 50%, so concurrent queries that
+// failed at the same time are not retried at the same time
+func (ph *Handler) getRetryDelay(attempt int) time.Duration {
+	backoff := ph.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// getRetriesMessage returns the number of retries as message that is reported with the SLI results
+func getRetriesMessage(retries int) string {
+	if retries == 0 {
+		return ""
+	}
+	return "query succeeded after " + strconv.Itoa(retries) + " retries"
+}
@@ -0,0 +1,144 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSLIResultsWithRetries(t *testing.T) {
+	attempts := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1571649085,"0.2"]}]}}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.RetryBackoff = time.Millisecond
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	results, err := ph.GetSLIResults(context.Background(), Throughput, start, end, logger)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, attempts)
+	assert.EqualValues(t, 1, len(results))
+	assert.EqualValues(t, 0.2, results[0].Value)
+	assert.EqualValues(t, "query succeeded after 2 retries", results[0].Message)
+}
+
+func TestGetSLIValueWithExhaustedRetries(t *testing.T) {
+	attempts := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.MaxAttempts = 2
+	ph.RetryBackoff = time.Millisecond
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	_, err := ph.GetSLIValue(context.Background(), Throughput, start, end, logger)
+
+	assert.EqualValues(t, 2, attempts)
+	assert.EqualError(t, err, "Prometheus query failed with status 502: Bad Gateway (after 1 retries)")
+}
+
+func TestGetSLIValueWithoutRetryOnBadRequest(t *testing.T) {
+	attempts := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.RetryBackoff = time.Millisecond
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	_, err := ph.GetSLIValue(context.Background(), Throughput, start, end, logger)
+
+	assert.EqualValues(t, 1, attempts)
+	assert.NotNil(t, err)
+}
+
+func TestGetSLIValueWithoutRetryOnCertificateError(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	var connections int32
+	s.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	s.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	s.StartTLS()
+	defer s.Close()
+
+	// the certificate of the test server is not trusted by the client
+	ph := NewPrometheusHandler(s.URL, "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = &http.Client{Transport: &http.Transport{}}
+	ph.RetryBackoff = time.Millisecond
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+	_, err := ph.GetSLIValue(context.Background(), Throughput, start, end, logger)
+
+	assert.NotNil(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&connections))
+}
+
+func TestIsTransientFailure(t *testing.T) {
+	assert.True(t, isTransientFailure(0, &url.Error{Op: "Get", URL: "http://prometheus", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}))
+	assert.True(t, isTransientFailure(0, &url.Error{Op: "Get", URL: "http://prometheus", Err: io.EOF}))
+	assert.True(t, isTransientFailure(0, &net.DNSError{Err: "i/o timeout", Name: "prometheus", IsTimeout: true}))
+	assert.False(t, isTransientFailure(0, &url.Error{Op: "Get", URL: "http://prometheus", Err: &net.DNSError{Err: "no such host", Name: "prometheus", IsNotFound: true}}))
+	assert.False(t, isTransientFailure(0, &url.Error{Op: "Get", URL: "ftp://prometheus", Err: errors.New("unsupported protocol scheme")}))
+	assert.True(t, isTransientFailure(http.StatusServiceUnavailable, nil))
+	assert.False(t, isTransientFailure(http.StatusBadRequest, nil))
+}
+
+func TestGetRetryDelay(t *testing.T) {
+	ph := &Handler{RetryBackoff: time.Second}
+
+	for attempt, backoff := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: maxRetryBackoff} {
+		delay := ph.getRetryDelay(attempt)
+		assert.True(t, delay >= backoff/2 && delay <= backoff, "attempt %d: %v", attempt, delay)
+	}
+}
@@ -0,0 +1,332 @@
+package prometheus
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sigV4Algorithm identifies the AWS Signature Version 4 in the Authorization header
+const sigV4Algorithm = "AWS4-HMAC-SHA256"
+
+// defaultSigV4Service is the signing name of Amazon Managed Service for Prometheus
+const defaultSigV4Service = "aps"
+
+// assumedRoleSessionName is the session name of roles assumed by the service
+const assumedRoleSessionName = "prometheus-sli-service"
+
+// assumedRoleRefreshWindow is the time before the expiration at which the credentials of an assumed role are renewed
+const assumedRoleRefreshWindow = time.Minute
+
+// SigV4Config defines how requests to Amazon Managed Service for Prometheus are signed. Without access key, the
+// credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, or are requested for the
+// role AWS_ROLE_ARN with the web identity token in AWS_WEB_IDENTITY_TOKEN_FILE (IAM roles for service accounts). If a
+// role is given, it is assumed with these credentials
+type SigV4Config struct {
+	Region      string `json:"region" yaml:"region"`
+	AccessKey   string `json:"access_key" yaml:"access_key"`
+	SecretKey   string `json:"secret_key" yaml:"secret_key"`
+	RoleARN     string `json:"role_arn" yaml:"role_arn"`
+	Service     string `json:"service" yaml:"service"`
+	STSEndpoint string `json:"sts_endpoint" yaml:"sts_endpoint"`
+}
+
+// awsCredentials are used to sign a request
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// assumedRoles caches the credentials of assumed roles until they are about to expire
+var assumedRoles = map[string]*awsCredentials{}
+var assumedRolesMutex sync.Mutex
+
+// signSigV4 signs a request with the credentials configured in the SigV4 section of the handler
+func (ph *Handler) signSigV4(req *http.Request) error {
+	if ph.SigV4.Region == "" {
+		return errors.New("invalid SigV4 configuration: region is missing")
+	}
+	credentials, err := ph.getSigV4Credentials(req)
+	if err != nil {
+		return err
+	}
+	service := ph.SigV4.Service
+	if service == "" {
+		service = defaultSigV4Service
+	}
+	signRequest(req, nil, credentials, ph.SigV4.Region, service, time.Now())
+	return nil
+}
+
+func (ph *Handler) getSigV4Credentials(req *http.Request) (*awsCredentials, error) {
+	credentials, err := ph.getBaseSigV4Credentials(req)
+	if err != nil {
+		return nil, err
+	}
+	if ph.SigV4.RoleARN == "" {
+		return credentials, nil
+	}
+
+	key := ph.SigV4.RoleARN + "\n" + credentials.AccessKeyID
+	return getCachedAWSCredentials(key, func() (*awsCredentials, error) {
+		query := url.Values{}
+		query.Set("Action", "AssumeRole")
+		query.Set("RoleArn", ph.SigV4.RoleARN)
+		query.Set("RoleSessionName", assumedRoleSessionName)
+		return ph.requestSTSCredentials(req, ph.SigV4.RoleARN, query, credentials)
+	})
+}
+
+// getBaseSigV4Credentials returns the access key of the SigV4 section, the access key of the environment, or the
+// credentials of the web identity of the environment, in this order
+func (ph *Handler) getBaseSigV4Credentials(req *http.Request) (*awsCredentials, error) {
+	if ph.SigV4.AccessKey != "" {
+		if ph.SigV4.SecretKey == "" {
+			return nil, errors.New("invalid SigV4 configuration: no AWS credentials found")
+		}
+		return &awsCredentials{AccessKeyID: ph.SigV4.AccessKey, SecretAccessKey: ph.SigV4.SecretKey}, nil
+	}
+	if accessKey := os.Getenv("AWS_ACCESS_KEY_ID"); accessKey != "" {
+		secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if secretKey == "" {
+			return nil, errors.New("invalid SigV4 configuration: no AWS credentials found")
+		}
+		return &awsCredentials{AccessKeyID: accessKey, SecretAccessKey: secretKey, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	if tokenFile == "" || roleARN == "" {
+		return nil, errors.New("invalid SigV4 configuration: no AWS credentials found")
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = assumedRoleSessionName
+	}
+	return getCachedAWSCredentials("web identity\n"+roleARN+"\n"+tokenFile, func() (*awsCredentials, error) {
+		// the token is rotated by Kubernetes, so it is read again whenever credentials are requested
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, errors.New("could not read web identity token: " + err.Error())
+		}
+		query := url.Values{}
+		query.Set("Action", "AssumeRoleWithWebIdentity")
+		query.Set("RoleArn", roleARN)
+		query.Set("RoleSessionName", sessionName)
+		query.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+		return ph.requestSTSCredentials(req, roleARN, query, nil)
+	})
+}
+
+// getCachedAWSCredentials returns the cached credentials of key, or fetches them if they are about to expire. The lock
+// is not held while credentials are fetched, so queries with other credentials do not wait for AWS STS
+func getCachedAWSCredentials(key string, fetch func() (*awsCredentials, error)) (*awsCredentials, error) {
+	assumedRolesMutex.Lock()
+	cached, ok := assumedRoles[key]
+	assumedRolesMutex.Unlock()
+	if ok && time.Now().Add(assumedRoleRefreshWindow).Before(cached.Expiration) {
+		return cached, nil
+	}
+
+	credentials, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	assumedRolesMutex.Lock()
+	assumedRoles[key] = credentials
+	assumedRolesMutex.Unlock()
+	return credentials, nil
+}
+
+type stsCredentials struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+type assumeRoleResponse struct {
+	Credentials            stsCredentials `xml:"AssumeRoleResult>Credentials"`
+	WebIdentityCredentials stsCredentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+type stsErrorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// requestSTSCredentials requests temporary credentials of a role from AWS STS. The request is signed with the given
+// credentials, AssumeRoleWithWebIdentity requests are not signed
+func (ph *Handler) requestSTSCredentials(req *http.Request, roleARN string, query url.Values, credentials *awsCredentials) (*awsCredentials, error) {
+	endpoint := ph.SigV4.STSEndpoint
+	if endpoint == "" {
+		endpoint = "https://sts." + ph.SigV4.Region + ".amazonaws.com"
+	}
+	query.Set("Version", "2011-06-15")
+
+	stsReq, err := http.NewRequest("GET", strings.TrimSuffix(endpoint, "/")+"/?"+query.Encode(), nil)
+	if err != nil {
+		return nil, errors.New("could not assume role " + roleARN + ": " + err.Error())
+	}
+	stsReq = stsReq.WithContext(req.Context())
+	if credentials != nil {
+		signRequest(stsReq, nil, credentials, ph.SigV4.Region, "sts", time.Now())
+	}
+
+	resp, err := ph.HTTPClient.Do(stsReq)
+	if err != nil {
+		return nil, errors.New("could not assume role " + roleARN + ": " + err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New("could not assume role " + roleARN + ": " + err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		stsError := &stsErrorResponse{}
+		if err := xml.Unmarshal(body, stsError); err != nil || stsError.Code == "" {
+			return nil, errors.New("could not assume role " + roleARN + ": " + http.StatusText(resp.StatusCode))
+		}
+		return nil, errors.New("could not assume role " + roleARN + ": " + stsError.Code + ": " + stsError.Message)
+	}
+
+	assumedRole := &assumeRoleResponse{}
+	if err := xml.Unmarshal(body, assumedRole); err != nil {
+		return nil, errors.New("could not assume role " + roleARN + ": invalid response of AWS STS")
+	}
+	result := assumedRole.Credentials
+	if result.AccessKeyID == "" {
+		result = assumedRole.WebIdentityCredentials
+	}
+	if result.AccessKeyID == "" {
+		return nil, errors.New("could not assume role " + roleARN + ": invalid response of AWS STS")
+	}
+	return &awsCredentials{
+		AccessKeyID:     result.AccessKeyID,
+		SecretAccessKey: result.SecretAccessKey,
+		SessionToken:    result.SessionToken,
+		Expiration:      result.Expiration,
+	}, nil
+}
+
+// signRequest adds the X-Amz-Date, X-Amz-Security-Token and Authorization headers of AWS Signature Version 4 to a
+// request. The host and all X-Amz-* headers are signed
+func signRequest(req *http.Request, body []byte, credentials *awsCredentials, region string, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	headers := map[string]string{}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers["host"] = host
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+		}
+	}
+	headerNames := []string{}
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	canonicalHeaders := ""
+	for _, name := range headerNames {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		getCanonicalURI(req.URL),
+		getCanonicalQuery(req.URL),
+		canonicalHeaders,
+		signedHeaders,
+		hashSHA256(body),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hashSHA256([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", sigV4Algorithm+" Credential="+credentials.AccessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// getCanonicalURI returns the path of a URL encoded twice, as required for all services except S3
+func getCanonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	return escapeSigV4(path, false)
+}
+
+// getCanonicalQuery returns the query parameters sorted by name and value
+func getCanonicalQuery(u *url.URL) string {
+	query := u.Query()
+	names := []string{}
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parameters := []string{}
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		for _, value := range values {
+			parameters = append(parameters, escapeSigV4(name, true)+"="+escapeSigV4(value, true))
+		}
+	}
+	return strings.Join(parameters, "&")
+}
+
+// escapeSigV4 percent-encodes all characters except the unreserved characters of RFC 3986 (and / within paths)
+func escapeSigV4(value string, encodeSlash bool) string {
+	escaped := strings.Builder{}
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && !encodeSlash) {
+			escaped.WriteByte(b)
+		} else {
+			escaped.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{b})))
+		}
+	}
+	return escaped.String()
+}
+
+func hashSHA256(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
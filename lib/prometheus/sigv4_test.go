@@ -0,0 +1,237 @@
+package prometheus
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
+	"github.com/stretchr/testify/assert"
+)
+
+// the test vectors are taken from the AWS Signature Version 4 test suite
+func TestSignRequestWithTestVectors(t *testing.T) {
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	credentials := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+	tests := map[string]string{
+		// get-vanilla
+		"https://example.amazonaws.com/": "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		// get-vanilla-query-order-key-case
+		"https://example.amazonaws.com/?Param2=value2&Param1=value1": "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		// get-vanilla-empty-query-key
+		"https://example.amazonaws.com/?Param1=value1": "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb",
+		// get-vanilla-query-unreserved
+		"https://example.amazonaws.com/?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz": "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197",
+	}
+	for requestURL, signature := range tests {
+		req, _ := http.NewRequest("GET", requestURL, nil)
+		signRequest(req, nil, credentials, "us-east-1", "service", now)
+
+		assert.EqualValues(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"), requestURL)
+		assert.EqualValues(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature="+signature, req.Header.Get("Authorization"), requestURL)
+	}
+}
+
+func TestSignRequestWithSessionToken(t *testing.T) {
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	credentials := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", SessionToken: "my-session-token"}
+
+	req, _ := http.NewRequest("GET", "https://aps-workspaces.eu-west-1.amazonaws.com/workspaces/ws-1234/api/v1/query?query=up", nil)
+	signRequest(req, nil, credentials, "eu-west-1", "aps", now)
+
+	assert.EqualValues(t, "my-session-token", req.Header.Get("X-Amz-Security-Token"))
+	assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/eu-west-1/aps/aws4_request, SignedHeaders=host;x-amz-date;x-amz-security-token, Signature="))
+}
+
+func TestGetCanonicalQuery(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/api/v1/query?query=sum(rate(http_requests_total%7Bjob%3D%22carts%22%7D%5B5m%5D))&time=1571649085", nil)
+	assert.EqualValues(t, "query=sum%28rate%28http_requests_total%7Bjob%3D%22carts%22%7D%5B5m%5D%29%29&time=1571649085", getCanonicalQuery(req.URL))
+	assert.EqualValues(t, "/api/v1/query", getCanonicalURI(req.URL))
+}
+
+func TestGetSLIValueWithSigV4AssumedRole(t *testing.T) {
+	stsRequests := 0
+	stsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stsRequests++
+		assert.EqualValues(t, "AssumeRole", r.URL.Query().Get("Action"))
+		assert.EqualValues(t, "arn:aws:iam::123456789012:role/prometheus-query", r.URL.Query().Get("RoleArn"))
+		assert.True(t, strings.Contains(r.Header.Get("Authorization"), "Credential=AKIDBASE/"))
+		assert.True(t, strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/sts/aws4_request"))
+		w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAASSUMED</AccessKeyId>
+      <SecretAccessKey>assumed-secret</SecretAccessKey>
+      <SessionToken>assumed-session-token</SessionToken>
+      <Expiration>` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`))
+	}))
+	defer stsServer.Close()
+
+	authorizations := []string{}
+	prometheusServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.EqualValues(t, "assumed-session-token", r.Header.Get("X-Amz-Security-Token"))
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1571649085,"1"]}}`))
+	}))
+	defer prometheusServer.Close()
+
+	ph := NewPrometheusHandler(prometheusServer.URL, "sockshop", "dev", "carts", nil)
+	ph.SigV4 = &SigV4Config{
+		Region:      "eu-west-1",
+		AccessKey:   "AKIDBASE",
+		SecretKey:   "base-secret",
+		RoleARN:     "arn:aws:iam::123456789012:role/prometheus-query",
+		STSEndpoint: stsServer.URL,
+	}
+
+	logger := keptncommon.NewLogger("", "", "")
+	for i := 0; i < 2; i++ {
+		value, err := ph.GetSLIValue(context.Background(), Throughput, "1571649084", "1571649085", logger)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 1.0, value)
+	}
+
+	// the credentials of the role are cached until they are about to expire
+	assert.EqualValues(t, 1, stsRequests)
+	assert.EqualValues(t, 2, len(authorizations))
+	for _, authorization := range authorizations {
+		assert.True(t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=ASIAASSUMED/"), authorization)
+		assert.True(t, strings.Contains(authorization, "/eu-west-1/aps/aws4_request"), authorization)
+	}
+}
+
+func TestGetSLIValueWithSigV4WebIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-identity")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("my-web-identity-token\n"), 0600))
+
+	for name, value := range map[string]string{
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile,
+		"AWS_ROLE_ARN":                "arn:aws:iam::123456789012:role/service-account",
+	} {
+		previous, ok := os.LookupEnv(name)
+		os.Setenv(name, value)
+		if ok {
+			defer os.Setenv(name, previous)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+
+	actions := []string{}
+	stsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		actions = append(actions, query.Get("Action"))
+		if query.Get("Action") == "AssumeRoleWithWebIdentity" {
+			// the token authenticates the request, it is not signed
+			assert.EqualValues(t, "arn:aws:iam::123456789012:role/service-account", query.Get("RoleArn"))
+			assert.EqualValues(t, "my-web-identity-token", query.Get("WebIdentityToken"))
+			assert.Empty(t, r.Header.Get("Authorization"))
+			w.Write([]byte(`<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>ASIAWEBIDENTITY</AccessKeyId>
+      <SecretAccessKey>web-identity-secret</SecretAccessKey>
+      <SessionToken>web-identity-session-token</SessionToken>
+      <Expiration>` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`))
+			return
+		}
+		// the role of the secret is assumed with the credentials of the web identity
+		assert.EqualValues(t, "arn:aws:iam::123456789012:role/prometheus-query", query.Get("RoleArn"))
+		assert.True(t, strings.Contains(r.Header.Get("Authorization"), "Credential=ASIAWEBIDENTITY/"))
+		w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIACHAINED</AccessKeyId>
+      <SecretAccessKey>chained-secret</SecretAccessKey>
+      <SessionToken>chained-session-token</SessionToken>
+      <Expiration>` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`))
+	}))
+	defer stsServer.Close()
+
+	authorizations := []string{}
+	prometheusServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1571649085,"1"]}}`))
+	}))
+	defer prometheusServer.Close()
+
+	ph := NewPrometheusHandler(prometheusServer.URL, "sockshop", "dev", "carts", nil)
+	logger := keptncommon.NewLogger("", "", "")
+
+	// without role, the credentials of the web identity are used
+	ph.SigV4 = &SigV4Config{Region: "eu-west-1", STSEndpoint: stsServer.URL}
+	_, err = ph.GetSLIValue(context.Background(), Throughput, "1571649084", "1571649085", logger)
+	assert.Nil(t, err)
+
+	ph.SigV4.RoleARN = "arn:aws:iam::123456789012:role/prometheus-query"
+	_, err = ph.GetSLIValue(context.Background(), Throughput, "1571649084", "1571649085", logger)
+	assert.Nil(t, err)
+
+	// the credentials of the web identity are cached as well
+	assert.EqualValues(t, []string{"AssumeRoleWithWebIdentity", "AssumeRole"}, actions)
+	if assert.EqualValues(t, 2, len(authorizations)) {
+		assert.True(t, strings.HasPrefix(authorizations[0], "AWS4-HMAC-SHA256 Credential=ASIAWEBIDENTITY/"), authorizations[0])
+		assert.True(t, strings.HasPrefix(authorizations[1], "AWS4-HMAC-SHA256 Credential=ASIACHAINED/"), authorizations[1])
+	}
+}
+
+func TestGetSLIValueWithSigV4AssumeRoleDenied(t *testing.T) {
+	stsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>AccessDenied</Code>
+    <Message>User is not authorized to perform: sts:AssumeRole</Message>
+  </Error>
+</ErrorResponse>`))
+	}))
+	defer stsServer.Close()
+
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+	ph.SigV4 = &SigV4Config{
+		Region:      "eu-west-1",
+		AccessKey:   "AKIDDENIED",
+		SecretKey:   "base-secret",
+		RoleARN:     "arn:aws:iam::123456789012:role/prometheus-query",
+		STSEndpoint: stsServer.URL,
+	}
+
+	logger := keptncommon.NewLogger("", "", "")
+	_, err := ph.GetSLIValue(context.Background(), Throughput, "1571649084", "1571649085", logger)
+
+	assert.EqualError(t, err, "could not assume role arn:aws:iam::123456789012:role/prometheus-query: AccessDenied: User is not authorized to perform: sts:AssumeRole")
+}
+
+func TestGetSLIValueWithSigV4WithoutCredentials(t *testing.T) {
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+
+	logger := keptncommon.NewLogger("", "", "")
+	ph.SigV4 = &SigV4Config{}
+	_, err := ph.GetSLIValue(context.Background(), Throughput, "1571649084", "1571649085", logger)
+	assert.EqualError(t, err, "invalid SigV4 configuration: region is missing")
+
+	ph.SigV4 = &SigV4Config{Region: "eu-west-1", AccessKey: "AKIDEXAMPLE"}
+	_, err = ph.GetSLIValue(context.Background(), Throughput, "1571649084", "1571649085", logger)
+	assert.EqualError(t, err, "invalid SigV4 configuration: no AWS credentials found")
+}
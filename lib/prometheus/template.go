@@ -0,0 +1,138 @@
+package prometheus
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// legacyVariableRegex matches the $VARIABLE placeholders that have been supported before templates were introduced.
+// A placeholder always spans the whole identifier, i.e. $SERVICE does not match the beginning of $SERVICE_NAME. The
+// form ${VARIABLE} delimits a placeholder that is followed by an identifier character, e.g. ${SERVICE}_${PROJECT}
+var legacyVariableRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// queryTemplateData is available in the templates of user-defined queries, e.g.:
+// rate(http_requests_total{job="{{ .Service }}-{{ .Project }}-{{ .Stage }}"{{ if .Filters.handler }},handler={{ quote .Filters.handler }}{{ end }}}[{{ .DurationSeconds }}])
+type queryTemplateData struct {
+	Project         string
+	Stage           string
+	Service         string
+	DurationSeconds string
+	Start           int64
+	End             int64
+	Filters         map[string]string
+}
+
+var queryTemplateFuncs = template.FuncMap{
+	"escape":      escapeLabelValue,
+	"regexEscape": escapeRegexLabelValue,
+	"quote":       quoteLabelValue,
+	"default":     defaultValue,
+}
+
+// escapeLabelValue escapes a value so it can be used within a double-quoted PromQL string. Single quotes must not be
+// escaped there, since PromQL only accepts escaped quotes of the same kind as the enclosing quotes
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// escapeRegexLabelValue escapes a value so it is matched literally within a quoted PromQL regular expression (=~, !~)
+func escapeRegexLabelValue(value string) string {
+	return escapeLabelValue(regexp.QuoteMeta(value))
+}
+
+// quoteLabelValue returns the value as escaped, double-quoted PromQL string
+func quoteLabelValue(value string) string {
+	return `"` + escapeLabelValue(value) + `"`
+}
+
+// defaultValue returns the value, or the given default if the value is empty, e.g. {{ default "ItemsController" .Filters.handler }}
+func defaultValue(defaultValue string, value string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// renderQuery renders the template of a user-defined query and replaces the legacy $VARIABLE placeholders afterwards
+func (ph *Handler) renderQuery(query string, start time.Time, end time.Time) (string, error) {
+	data := ph.getTemplateData(start, end)
+
+	tmpl, err := template.New("query").Funcs(queryTemplateFuncs).Option("missingkey=zero").Parse(query)
+	if err != nil {
+		return "", errors.New("invalid query template: " + err.Error())
+	}
+	renderedQuery := &bytes.Buffer{}
+	if err := tmpl.Execute(renderedQuery, data); err != nil {
+		return "", errors.New("could not render query template: " + err.Error())
+	}
+
+	return replaceLegacyVariables(renderedQuery.String(), data), nil
+}
+
+// renderTenantID renders the tenant ID of the handler, which supports the same templates and placeholders as queries,
+// e.g. {{ .Project }}-{{ .Stage }} or $PROJECT-$STAGE
+func (ph *Handler) renderTenantID() (string, error) {
+	data := ph.getTemplateData(time.Time{}, time.Time{})
+
+	tmpl, err := template.New("tenant").Funcs(queryTemplateFuncs).Option("missingkey=zero").Parse(ph.TenantID)
+	if err != nil {
+		return "", errors.New("invalid tenant ID template: " + err.Error())
+	}
+	renderedTenantID := &bytes.Buffer{}
+	if err := tmpl.Execute(renderedTenantID, data); err != nil {
+		return "", errors.New("could not render tenant ID template: " + err.Error())
+	}
+
+	return strings.TrimSpace(replaceLegacyVariables(renderedTenantID.String(), data)), nil
+}
+
+func (ph *Handler) getTemplateData(start time.Time, end time.Time) queryTemplateData {
+	data := queryTemplateData{
+		Project:         ph.Project,
+		Stage:           ph.Stage,
+		Service:         ph.Service,
+		DurationSeconds: strconv.FormatInt(getDurationInSeconds(start, end), 10) + "s",
+		Start:           start.Unix(),
+		End:             end.Unix(),
+		Filters:         map[string]string{},
+	}
+	for _, filter := range ph.CustomFilters {
+		data.Filters[filter.Key] = stripQuotes(filter.Value)
+	}
+	return data
+}
+
+func replaceLegacyVariables(query string, data queryTemplateData) string {
+	variables := map[string]string{
+		"PROJECT":          data.Project,
+		"project":          data.Project,
+		"STAGE":            data.Stage,
+		"stage":            data.Stage,
+		"SERVICE":          data.Service,
+		"service":          data.Service,
+		"DURATION_SECONDS": data.DurationSeconds,
+	}
+	for key, value := range data.Filters {
+		variables[key] = value
+		variables[strings.ToUpper(key)] = value
+	}
+
+	return legacyVariableRegex.ReplaceAllStringFunc(query, func(placeholder string) string {
+		match := legacyVariableRegex.FindStringSubmatch(placeholder)
+		name := match[1] + match[2]
+		if value, ok := variables[name]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+func stripQuotes(value string) string {
+	value = strings.Replace(value, "'", "", -1)
+	return strings.Replace(value, "\"", "", -1)
+}
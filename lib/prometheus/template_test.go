@@ -0,0 +1,113 @@
+package prometheus
+
+import (
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRenderQueryWithTemplate(t *testing.T) {
+	customFilters := []*keptnv2.SLIFilter{
+		{Key: "handler", Value: "ItemsController"},
+	}
+	ph := NewPrometheusHandler("prometheus", "sockshop", "dev", "carts", customFilters)
+
+	start := time.Unix(1571649055, 0)
+	end := time.Unix(1571649085, 0)
+	query, err := ph.renderQuery(`rate(http_requests_total{job="{{ .Service }}-{{ .Project }}-{{ .Stage }}"{{ if .Filters.handler }},handler={{ quote .Filters.handler }}{{ end }}{{ if .Filters.method }},method="{{ .Filters.method }}"{{ end }}}[{{ .DurationSeconds }}])`, start, end)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, `rate(http_requests_total{job="carts-sockshop-dev",handler="ItemsController"}[30s])`, query)
+}
+
+func TestRenderQueryWithEscapeFunctions(t *testing.T) {
+	customFilters := []*keptnv2.SLIFilter{
+		{Key: "path", Value: `/api/items.json`},
+		{Key: "user", Value: `O\Brien`},
+	}
+	ph := NewPrometheusHandler("prometheus", "sockshop", "dev", "carts", customFilters)
+
+	start := time.Unix(1571649055, 0)
+	end := time.Unix(1571649085, 0)
+	query, err := ph.renderQuery(`up{path=~"{{ regexEscape .Filters.path }}",user="{{ escape .Filters.user }}",handler="{{ default "all" .Filters.handler }}"}`, start, end)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, `up{path=~"/api/items\\.json",user="O\\Brien",handler="all"}`, query)
+}
+
+func TestRenderQueryWithQuotesInValues(t *testing.T) {
+	ph := NewPrometheusHandler("prometheus", "sockshop", "dev", "carts", nil)
+
+	start := time.Unix(1571649055, 0)
+	end := time.Unix(1571649085, 0)
+	query, err := ph.renderQuery(`up{owner={{ quote "O'Brien" }},team="{{ escape "say \"hi\"" }}",name=~"{{ regexEscape "O'Brien (ops)" }}"}`, start, end)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, `up{owner="O'Brien",team="say \"hi\"",name=~"O'Brien \\(ops\\)"}`, query)
+	assert.Nil(t, ValidateQuery(query))
+}
+
+func TestRenderQueryWithLegacyVariables(t *testing.T) {
+	customFilters := []*keptnv2.SLIFilter{
+		{Key: "handler", Value: "ItemsController"},
+		{Key: "handler_group", Value: "Items"},
+	}
+	ph := NewPrometheusHandler("prometheus", "sockshop", "dev", "carts", customFilters)
+
+	start := time.Unix(1571649055, 0)
+	end := time.Unix(1571649085, 0)
+	query, err := ph.renderQuery("up{job='$SERVICE-$PROJECT-$STAGE',handler='$HANDLER',group='$handler_group',replica='$REPLICA'}[$DURATION_SECONDS]", start, end)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, "up{job='carts-sockshop-dev',handler='ItemsController',group='Items',replica='$REPLICA'}[30s]", query)
+}
+
+func TestRenderQueryWithAdjacentLegacyVariables(t *testing.T) {
+	ph := NewPrometheusHandler("prometheus", "sockshop", "dev", "carts", nil)
+
+	start := time.Unix(1571649055, 0)
+	end := time.Unix(1571649085, 0)
+	query, err := ph.renderQuery(`up{job="${SERVICE}_${PROJECT}",name="$SERVICE_NAME",project="$PROJECTS",other="${SERVICE_NAME}",group="${1}"}`, start, end)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, `up{job="carts_sockshop",name="$SERVICE_NAME",project="$PROJECTS",other="${SERVICE_NAME}",group="${1}"}`, query)
+
+	// a filter that matches the whole name is replaced
+	ph.CustomFilters = []*keptnv2.SLIFilter{{Key: "service_name", Value: "cart-service"}}
+	query, err = ph.renderQuery(`up{name="$SERVICE_NAME",other="${service_name}"}`, start, end)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, `up{name="cart-service",other="cart-service"}`, query)
+}
+
+func TestRenderQueryWithInvalidTemplate(t *testing.T) {
+	ph := NewPrometheusHandler("prometheus", "sockshop", "dev", "carts", nil)
+
+	start := time.Unix(1571649055, 0)
+	end := time.Unix(1571649085, 0)
+	_, err := ph.renderQuery(`up{job="{{ .Service "}`, start, end)
+
+	assert.NotNil(t, err)
+}
+
+func TestRenderTenantID(t *testing.T) {
+	ph := NewPrometheusHandler("http://prometheus", "sockshop", "dev", "carts", nil)
+
+	tests := map[string]string{
+		"team-a":                        "team-a",
+		"$PROJECT-$STAGE":               "sockshop-dev",
+		"{{ .Project }}-{{ .Service }}": "sockshop-carts",
+		"{{ if eq .Stage \"prod\" }}prod{{ else }}non-prod{{ end }}": "non-prod",
+	}
+	for tenantID, expected := range tests {
+		ph.TenantID = tenantID
+		renderedTenantID, err := ph.renderTenantID()
+		assert.Nil(t, err, tenantID)
+		assert.EqualValues(t, expected, renderedTenantID, tenantID)
+	}
+
+	ph.TenantID = "{{ .Project "
+	_, err := ph.renderTenantID()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,142 @@
+package prometheus
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const optionPartialResponse = "PARTIAL_RESPONSE"
+const optionDedup = "DEDUP"
+const optionMaxSourceResolution = "MAX_SOURCE_RESOLUTION"
+const optionReplicaLabels = "REPLICA_LABELS"
+
+// autoSourceResolution lets Thanos select the downsampling resolution based on the step of the query
+const autoSourceResolution = "auto"
+
+// QueryParameters are additional parameters of the query API of Thanos and of Cortex or Mimir in front of Thanos
+// stores. Parameters that are not set are not sent, so the defaults of the querier apply
+type QueryParameters struct {
+	// PartialResponse allows results without the data of unavailable stores. Such results are reported as partial
+	PartialResponse *bool `json:"partial_response" yaml:"partial_response"`
+	// Dedup removes the series of replicas, which differ in the replica labels only
+	Dedup *bool `json:"dedup" yaml:"dedup"`
+	// MaxSourceResolution is auto or the maximum resolution of downsampled data, e.g. 0s (raw data only), 5m or 1h
+	MaxSourceResolution string `json:"max_source_resolution" yaml:"max_source_resolution"`
+	// ReplicaLabels replace the replica labels configured in the querier for deduplication
+	ReplicaLabels []string `json:"replica_labels" yaml:"replica_labels"`
+}
+
+// override returns the parameters with all parameters that are set in other replaced
+func (p QueryParameters) override(other QueryParameters) QueryParameters {
+	if other.PartialResponse != nil {
+		p.PartialResponse = other.PartialResponse
+	}
+	if other.Dedup != nil {
+		p.Dedup = other.Dedup
+	}
+	if other.MaxSourceResolution != "" {
+		p.MaxSourceResolution = other.MaxSourceResolution
+	}
+	if len(other.ReplicaLabels) > 0 {
+		p.ReplicaLabels = other.ReplicaLabels
+	}
+	return p
+}
+
+func (p QueryParameters) validate() error {
+	if p.MaxSourceResolution != "" {
+		if err := validateMaxSourceResolution(p.MaxSourceResolution); err != nil {
+			return errors.New("invalid max_source_resolution: " + err.Error())
+		}
+	}
+	for _, label := range p.ReplicaLabels {
+		if label == "" {
+			return errors.New("invalid replica_labels: empty label name")
+		}
+	}
+	return nil
+}
+
+// encode returns the parameters that are set as query string, starting with &
+func (p QueryParameters) encode() string {
+	query := ""
+	if p.PartialResponse != nil {
+		query += "&partial_response=" + strconv.FormatBool(*p.PartialResponse)
+	}
+	if p.Dedup != nil {
+		query += "&dedup=" + strconv.FormatBool(*p.Dedup)
+	}
+	if p.MaxSourceResolution != "" {
+		query += "&max_source_resolution=" + url.QueryEscape(p.MaxSourceResolution)
+	}
+	for _, label := range p.ReplicaLabels {
+		query += "&" + url.QueryEscape("replicaLabels[]") + "=" + url.QueryEscape(label)
+	}
+	return query
+}
+
+// isPartialResponseAllowed returns true if partial responses have been allowed explicitly, or if other Thanos parameters
+// are set and partial responses have not been disabled, since Thanos queriers allow them by default. Warnings of other
+// Prometheus-compatible endpoints do not indicate missing data
+func (p QueryParameters) isPartialResponseAllowed() bool {
+	if p.PartialResponse != nil {
+		return *p.PartialResponse
+	}
+	return p.Dedup != nil || p.MaxSourceResolution != "" || len(p.ReplicaLabels) > 0
+}
+
+func validateMaxSourceResolution(value string) error {
+	if value == autoSourceResolution {
+		return nil
+	}
+	resolution, err := time.ParseDuration(value)
+	if err != nil || resolution < 0 {
+		return errors.New(value + " (expected " + autoSourceResolution + " or a duration, e.g. 0s, 5m or 1h)")
+	}
+	return nil
+}
+
+// parseQueryParameterOption sets the query parameter of an indicator option, it returns false for other options
+func parseQueryParameterOption(key string, value string, parameters *QueryParameters) (bool, error) {
+	switch key {
+	case optionPartialResponse, optionDedup:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return true, errors.New("invalid value for option " + key + ": " + value + " (expected true or false)")
+		}
+		if key == optionPartialResponse {
+			parameters.PartialResponse = &enabled
+		} else {
+			parameters.Dedup = &enabled
+		}
+	case optionMaxSourceResolution:
+		if err := validateMaxSourceResolution(value); err != nil {
+			return true, errors.New("invalid value for option " + key + ": " + err.Error())
+		}
+		parameters.MaxSourceResolution = value
+	case optionReplicaLabels:
+		labels := []string{}
+		for _, label := range strings.Split(value, ",") {
+			label = strings.TrimSpace(label)
+			if label == "" {
+				return true, errors.New("invalid value for option " + key + ": " + value + " (expected comma-separated label names)")
+			}
+			labels = append(labels, label)
+		}
+		parameters.ReplicaLabels = labels
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// getQueryParameters returns the query parameters of the handler, overridden by the options of the indicator
+func (ph *Handler) getQueryParameters(options *indicatorOptions) (QueryParameters, error) {
+	if err := ph.QueryParameters.validate(); err != nil {
+		return QueryParameters{}, err
+	}
+	return ph.QueryParameters.override(options.QueryParameters), nil
+}
@@ -0,0 +1,129 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeQueryParameters(t *testing.T) {
+	enabled := true
+	disabled := false
+
+	assert.EqualValues(t, "", QueryParameters{}.encode())
+
+	parameters := QueryParameters{
+		PartialResponse:     &disabled,
+		Dedup:               &enabled,
+		MaxSourceResolution: "5m",
+		ReplicaLabels:       []string{"replica", "prometheus_replica"},
+	}
+	assert.EqualValues(t, "&partial_response=false&dedup=true&max_source_resolution=5m&replicaLabels%5B%5D=replica&replicaLabels%5B%5D=prometheus_replica", parameters.encode())
+}
+
+func TestParseIndicatorQueryWithQueryParameters(t *testing.T) {
+	options, query, err := parseIndicatorQuery("PARTIAL_RESPONSE=false;DEDUP=true;MAX_SOURCE_RESOLUTION=1h;REPLICA_LABELS=replica, rule_replica;up")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "up", query)
+	assert.False(t, *options.QueryParameters.PartialResponse)
+	assert.True(t, *options.QueryParameters.Dedup)
+	assert.EqualValues(t, "1h", options.QueryParameters.MaxSourceResolution)
+	assert.EqualValues(t, []string{"replica", "rule_replica"}, options.QueryParameters.ReplicaLabels)
+
+	options, _, err = parseIndicatorQuery("MAX_SOURCE_RESOLUTION=auto;up")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "auto", options.QueryParameters.MaxSourceResolution)
+
+	_, _, err = parseIndicatorQuery("PARTIAL_RESPONSE=sometimes;up")
+	assert.EqualError(t, err, "invalid value for option PARTIAL_RESPONSE: sometimes (expected true or false)")
+
+	_, _, err = parseIndicatorQuery("MAX_SOURCE_RESOLUTION=1d;up")
+	assert.EqualError(t, err, "invalid value for option MAX_SOURCE_RESOLUTION: 1d (expected auto or a duration, e.g. 0s, 5m or 1h)")
+
+	_, _, err = parseIndicatorQuery("REPLICA_LABELS=replica,;up")
+	assert.EqualError(t, err, "invalid value for option REPLICA_LABELS: replica, (expected comma-separated label names)")
+}
+
+func TestGetQueryParameters(t *testing.T) {
+	enabled := true
+	disabled := false
+
+	ph := NewPrometheusHandler("http://thanos", "sockshop", "dev", "carts", nil)
+	ph.QueryParameters = QueryParameters{PartialResponse: &enabled, Dedup: &enabled, ReplicaLabels: []string{"replica"}}
+
+	// options of the indicator override the parameters of the datasource
+	parameters, err := ph.getQueryParameters(&indicatorOptions{QueryParameters: QueryParameters{PartialResponse: &disabled, MaxSourceResolution: "5m"}})
+	assert.Nil(t, err)
+	assert.False(t, *parameters.PartialResponse)
+	assert.True(t, *parameters.Dedup)
+	assert.EqualValues(t, "5m", parameters.MaxSourceResolution)
+	assert.EqualValues(t, []string{"replica"}, parameters.ReplicaLabels)
+
+	// the handler is not modified
+	assert.True(t, *ph.QueryParameters.PartialResponse)
+
+	ph.QueryParameters.MaxSourceResolution = "raw"
+	_, err = ph.getQueryParameters(&indicatorOptions{})
+	assert.EqualError(t, err, "invalid max_source_resolution: raw (expected auto or a duration, e.g. 0s, 5m or 1h)")
+}
+
+func TestGetSLIResultsWithPartialResponse(t *testing.T) {
+	var query url.Values
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(`{"status":"success","warnings":["receive store unavailable"],"data":{"resultType":"vector","result":[{"metric":{},"value":[1571649085,"0.2"]}]}}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	enabled := true
+	ph := NewPrometheusHandler("http://thanos", "sockshop", "dev", "carts", nil)
+	ph.HTTPClient = httpClient
+	ph.QueryParameters = QueryParameters{PartialResponse: &enabled, ReplicaLabels: []string{"replica"}}
+	ph.CustomQueries = map[string]string{
+		"throughput": "DEDUP=true;sum(rate(http_requests_total[5m]))",
+		"error_rate": "PARTIAL_RESPONSE=false;sum(rate(http_requests_total{status=~\"5..\"}[5m]))",
+	}
+
+	start := strconv.FormatInt(time.Unix(1571649084, 0).UTC().UnixNano(), 10)
+	end := strconv.FormatInt(time.Unix(1571649085, 0).UTC().UnixNano(), 10)
+	logger := keptncommon.NewLogger("", "", "")
+
+	results, err := ph.GetSLIResults(context.Background(), "throughput", start, end, logger)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "true", query.Get("partial_response"))
+	assert.EqualValues(t, "true", query.Get("dedup"))
+	assert.EqualValues(t, []string{"replica"}, query["replicaLabels[]"])
+	assert.EqualValues(t, 0.2, results[0].Value)
+	assert.True(t, results[0].Success)
+	assert.EqualValues(t, "partial response, the result may be incomplete; Prometheus warnings: receive store unavailable", results[0].Message)
+
+	// without partial responses, warnings are not reported as partial response
+	results, err = ph.GetSLIResults(context.Background(), "error_rate", start, end, logger)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "false", query.Get("partial_response"))
+	assert.EqualValues(t, "", query.Get("dedup"))
+	assert.EqualValues(t, "Prometheus warnings: receive store unavailable", results[0].Message)
+
+	// without the parameter, a Thanos querier allows partial responses by default
+	ph.QueryParameters = QueryParameters{ReplicaLabels: []string{"replica"}}
+	results, err = ph.GetSLIResults(context.Background(), "throughput", start, end, logger)
+	assert.Nil(t, err)
+	_, ok := query["partial_response"]
+	assert.False(t, ok)
+	assert.EqualValues(t, "partial response, the result may be incomplete; Prometheus warnings: receive store unavailable", results[0].Message)
+
+	// without any Thanos parameters, warnings do not indicate a partial response
+	ph.QueryParameters = QueryParameters{}
+	ph.CustomQueries["throughput"] = "sum(rate(http_requests_total[5m]))"
+	results, err = ph.GetSLIResults(context.Background(), "throughput", start, end, logger)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "Prometheus warnings: receive store unavailable", results[0].Message)
+}
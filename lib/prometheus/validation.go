@@ -0,0 +1,31 @@
+package prometheus
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ValidateCustomQueries checks the options, templates and PromQL syntax of user-defined queries without sending them
+// to Prometheus. Placeholders are rendered with sample values, and one error is returned per invalid indicator
+func ValidateCustomQueries(customQueries map[string]string) []error {
+	ph := NewPrometheusHandler("", "project", "stage", "service", nil)
+	ph.CustomQueries = customQueries
+
+	end := time.Now()
+	start := end.Add(-time.Hour)
+
+	metrics := []string{}
+	for metric := range customQueries {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	errs := []error{}
+	for _, metric := range metrics {
+		if _, err := ph.getMetricQuery(metric, start, end); err != nil {
+			errs = append(errs, errors.New(metric+": "+err.Error()))
+		}
+	}
+	return errs
+}
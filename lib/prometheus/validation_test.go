@@ -0,0 +1,25 @@
+package prometheus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateCustomQueries(t *testing.T) {
+	customQueries := map[string]string{
+		"throughput":        "sum(rate(http_requests_total{job='$SERVICE-$PROJECT-$STAGE'}[$DURATION_SECONDS]))",
+		"error_rate":        "sum(rate(http_requests_total{job='$SERVICE-$PROJECT-$STAGE',status!~'2..'}[$DURATION_SECONDS])",
+		"response_time_p95": "MODE=range;histogram_quantile(0.95,sum(rate(http_response_time_milliseconds_bucket{job='{{ .Service }}'}[1m]))by(le))",
+		"cpu_usage":         "MODE=range;container_cpu_usage_seconds_total{namespace='$PROJECT-$STAGE'}[5m]",
+		"memory":            "AGGREGATION=median;container_memory_usage_bytes",
+		"templated":         "up{job='{{ .Service'}",
+	}
+
+	errs := ValidateCustomQueries(customQueries)
+
+	if assert.Len(t, errs, 3) {
+		assert.EqualValues(t, "error_rate: invalid PromQL query: line 1, column 4: unclosed left parenthesis", errs[0].Error())
+		assert.Contains(t, errs[1].Error(), "memory: invalid value for option AGGREGATION")
+		assert.Contains(t, errs[2].Error(), "templated: invalid query template")
+	}
+}
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"github.com/cloudevents/sdk-go/v2/types"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 	"log"
 	"math"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/keptn-contrib/prometheus-sli-service/lib/prometheus"
 	"gopkg.in/yaml.v2"
@@ -21,29 +25,90 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
 	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
 const configservice = "CONFIGURATION_SERVICE"
 const eventbroker = "EVENTBROKER"
 const sliResourceURI = "prometheus/sli.yaml"
 const serviceName = "prometheus-sli-service"
+const credentialsSecretKey = "prometheus-credentials"
 
 type envConfig struct {
 	// Port on which to listen for cloudevents
 	Port int    `envconfig:"RCV_PORT" default:"8080"`
 	Path string `envconfig:"RCV_PATH" default:"/"`
+	// EmptyResultPolicy defines how indicators without data are reported: fail, zero, warn or default:<value>
+	EmptyResultPolicy string `envconfig:"EMPTY_RESULT_POLICY" default:"zero"`
+	// QueryTimeout is the time a single indicator may take unless it defines its own timeout
+	QueryTimeout time.Duration `envconfig:"QUERY_TIMEOUT" default:"30s"`
+	// EvaluationTimeout is the time all indicators of an event may take
+	EvaluationTimeout time.Duration `envconfig:"EVALUATION_TIMEOUT" default:"5m"`
+	// MaxConcurrentQueries is the number of indicators of an event that are retrieved at the same time
+	MaxConcurrentQueries int `envconfig:"MAX_CONCURRENT_QUERIES" default:"5"`
+	// QueryMaxAttempts is the number of times a query is sent if it fails transiently
+	QueryMaxAttempts int `envconfig:"QUERY_MAX_ATTEMPTS" default:"3"`
+	// QueryRetryBackoff is the delay before the first retry of a query
+	QueryRetryBackoff time.Duration `envconfig:"QUERY_RETRY_BACKOFF" default:"1s"`
+	// DefaultPrometheusURL is used for projects without credentials secret
+	DefaultPrometheusURL string `envconfig:"DEFAULT_PROMETHEUS_URL" default:"http://prometheus-service.monitoring.svc.cluster.local:8080"`
+	// ServiceCredentialsSecretName, StageCredentialsSecretName and CredentialsSecretName are the templates of the names
+	// of the credentials secrets of a service, a stage and a project. They are looked up in this order. The service and
+	// stage secrets are disabled by default, since e.g. prometheus-credentials-{{ .Project }}-{{ .Stage }} of project
+	// sockshop and stage dev would match the project secret of a project sockshop-dev
+	ServiceCredentialsSecretName string `envconfig:"SERVICE_CREDENTIALS_SECRET_NAME"`
+	StageCredentialsSecretName   string `envconfig:"STAGE_CREDENTIALS_SECRET_NAME"`
+	CredentialsSecretName        string `envconfig:"CREDENTIALS_SECRET_NAME" default:"prometheus-credentials-{{ .Project }}"`
+	// CredentialsProvider is the source of the credentials secrets: kubernetes, directory or env
+	CredentialsProvider string `envconfig:"CREDENTIALS_PROVIDER" default:"kubernetes"`
+	// CredentialsDirectory contains a file or a directory per credentials secret for the directory provider
+	CredentialsDirectory string `envconfig:"CREDENTIALS_DIRECTORY"`
+	// FailOnMissingSecret fails the evaluation if the credentials secret can not be read, instead of using the default URL
+	FailOnMissingSecret bool `envconfig:"FAIL_ON_MISSING_SECRET" default:"false"`
+}
+
+// secretNameData is available in the template of the name of the credentials secret
+type secretNameData struct {
+	Project string
+	Stage   string
+	Service string
 }
 
 type prometheusCredentials struct {
-	URL      string `json:"url" yaml:"url"`
-	User     string `json:"user" yaml:"user"`
-	Password string `json:"password" yaml:"password"`
+	URL string `json:"url" yaml:"url"`
+	// URLs are replicas of the prometheus instance, queried in this order after URL if a replica is not available
+	URLs            []string                 `json:"urls" yaml:"urls"`
+	HealthCheckPath string                   `json:"health_check_path" yaml:"health_check_path"`
+	User            string                   `json:"user" yaml:"user"`
+	Password        string                   `json:"password" yaml:"password"`
+	BearerToken     string                   `json:"bearer_token" yaml:"bearer_token"`
+	BearerTokenFile string                   `json:"bearer_token_file" yaml:"bearer_token_file"`
+	OAuth2          *prometheus.OAuth2Config `json:"oauth2" yaml:"oauth2"`
+	SigV4           *prometheus.SigV4Config  `json:"sigv4" yaml:"sigv4"`
+	Headers         map[string]string        `json:"headers" yaml:"headers"`
+	TenantID        string                   `json:"tenant_id" yaml:"tenant_id"`
+	ProxyURL        string                   `json:"proxy_url" yaml:"proxy_url"`
+	NoProxy         string                   `json:"no_proxy" yaml:"no_proxy"`
+	TLS             *prometheus.TLSConfig    `json:"tls" yaml:"tls"`
+	// QueryParameters are sent to Thanos with each query
+	QueryParameters prometheus.QueryParameters `json:"query_parameters" yaml:"query_parameters"`
+	// Datasources are further prometheus instances, selected per indicator with the DATASOURCE option
+	Datasources map[string]*prometheusCredentials `json:"datasources" yaml:"datasources"`
+	// Stages and Services replace the settings above for single stages, or services of a stage
+	Stages   map[string]*prometheusCredentials `json:"stages" yaml:"stages"`
+	Services map[string]*prometheusCredentials `json:"services" yaml:"services"`
 }
 
 var namespace = os.Getenv("POD_NAMESPACE")
 
+// config holds the settings of the service read from the environment
+var config envConfig
+
+// credentialsSecrets provides the secrets with the credentials of the prometheus instances
+var credentialsSecrets credentialsProvider
+
+// emptyResultPolicy is applied to all indicators that do not define their own policy
+var emptyResultPolicy prometheus.EmptyResultPolicy
+
 func main() {
 	var env envConfig
 	if err := envconfig.Process("", &env); err != nil {
@@ -53,6 +118,31 @@ func main() {
 }
 
 func _main(args []string, env envConfig) int {
+	if len(args) > 0 && args[0] == validateCommand {
+		return validateSLIFiles(args[1:], os.Stdout)
+	}
+
+	policy, err := prometheus.ParseEmptyResultPolicy(env.EmptyResultPolicy)
+	if err != nil {
+		log.Fatalf("invalid EMPTY_RESULT_POLICY: %v", err)
+	}
+	emptyResultPolicy = policy
+	for variable, nameTemplate := range map[string]string{
+		"SERVICE_CREDENTIALS_SECRET_NAME": env.ServiceCredentialsSecretName,
+		"STAGE_CREDENTIALS_SECRET_NAME":   env.StageCredentialsSecretName,
+		"CREDENTIALS_SECRET_NAME":         env.CredentialsSecretName,
+	} {
+		if _, err := template.New("secret").Parse(nameTemplate); err != nil {
+			log.Fatalf("invalid %s: %v", variable, err)
+		}
+	}
+	config = env
+
+	secrets, err := newCredentialsProvider(env, make(chan struct{}))
+	if err != nil {
+		log.Fatalf("could not create credentials provider: %v", err)
+	}
+	credentialsSecrets = secrets
 
 	ctx := context.Background()
 	ctx = cloudevents.WithEncodingStructured(ctx)
@@ -70,17 +160,17 @@ func _main(args []string, env envConfig) int {
 	return 0
 }
 
-func gotEvent(event cloudevents.Event) error {
+func gotEvent(ctx context.Context, event cloudevents.Event) error {
 
 	switch event.Type() {
 	case keptnv2.GetTriggeredEventType(keptnv2.GetSLITaskName):
-		return processEvent(event) // backwards compatibility to Keptn versions <= 0.5.x
+		return processEvent(ctx, event) // backwards compatibility to Keptn versions <= 0.5.x
 	default:
 		return errors.New("received unknown event type")
 	}
 }
 
-func processEvent(event cloudevents.Event) error {
+func processEvent(ctx context.Context, event cloudevents.Event) error {
 
 	eventData := &keptnv2.GetSLITriggeredEventData{}
 	err := event.DataAs(eventData)
@@ -106,8 +196,10 @@ func processEvent(event cloudevents.Event) error {
 		return sendGetSLIFinishedEvent(event, eventData, sliResults, err, keptnCtx)
 	}
 
-	// 2: try to fetch metrics
-	if sliResults, err = retrieveMetrics(event, eventData, log); err != nil {
+	// 2: try to fetch metrics; the deadline only applies to the queries, so the .finished event is sent in any case
+	evaluationCtx, cancel := context.WithTimeout(ctx, config.EvaluationTimeout)
+	defer cancel()
+	if sliResults, err = retrieveMetrics(evaluationCtx, event, eventData, log); err != nil {
 		return sendGetSLIFinishedEvent(event, eventData, sliResults, err, keptnCtx)
 	}
 
@@ -115,22 +207,14 @@ func processEvent(event cloudevents.Event) error {
 	return sendGetSLIFinishedEvent(event, eventData, sliResults, nil, keptnCtx)
 }
 
-func retrieveMetrics(event cloudevents.Event, eventData *keptnv2.GetSLITriggeredEventData, log keptncommon.LoggerInterface) ([]*keptnv2.SLIResult, error) {
+func retrieveMetrics(ctx context.Context, event cloudevents.Event, eventData *keptnv2.GetSLITriggeredEventData, log keptncommon.LoggerInterface) ([]*keptnv2.SLIResult, error) {
 	log.Info("Retrieving Prometheus metrics")
 
-	clusterConfig, err := rest.InClusterConfig()
+	credentials, source, err := getPrometheusCredentials(eventData.Project, eventData.Stage, eventData.Service, credentialsSecrets, log)
 	if err != nil {
-		log.Error("could not create Kubernetes cluster config")
-		return nil, errors.New("could not create Kubernetes client")
+		return nil, err
 	}
-
-	kubeClient, err := kubernetes.NewForConfig(clusterConfig)
-	if err != nil {
-		log.Error("could not create Kubernetes client")
-		return nil, errors.New("could not create Kubernetes client")
-	}
-
-	prometheusApiURL, err := getPrometheusApiURL(eventData.Project, kubeClient.CoreV1(), log)
+	handlers, err := newDatasourceHandlers(credentials, source, eventData, log)
 	if err != nil {
 		return nil, err
 	}
@@ -145,8 +229,6 @@ func retrieveMetrics(event cloudevents.Event, eventData *keptnv2.GetSLITriggered
 		return nil, err
 	}
 
-	prometheusHandler := prometheus.NewPrometheusHandler(prometheusApiURL, eventData.Project, eventData.Stage, eventData.Service, eventData.GetSLI.CustomFilters)
-
 	projectCustomQueries, err := getCustomQueries(keptnHandler, eventData.Project, eventData.Stage, eventData.Service, log)
 	if err != nil {
 		log.Error("Failed to get custom queries for project " + eventData.Project)
@@ -155,37 +237,235 @@ func retrieveMetrics(event cloudevents.Event, eventData *keptnv2.GetSLITriggered
 	}
 
 	if projectCustomQueries != nil {
-		prometheusHandler.CustomQueries = projectCustomQueries
+		for _, handler := range handlers {
+			handler.CustomQueries = projectCustomQueries
+		}
+	}
+
+	return getSLIResults(ctx, handlers, eventData.GetSLI.Indicators, eventData.GetSLI.Start, eventData.GetSLI.End, config.MaxConcurrentQueries, log), nil
+}
+
+// defaultDatasource is the name of the prometheus instance of the credentials, which is queried unless an indicator
+// selects other datasources
+const defaultDatasource = "default"
+
+// datasourceHandlers holds a handler per datasource of the credentials
+type datasourceHandlers map[string]*prometheus.Handler
+
+// newDatasourceHandlers creates the handlers of the default datasource and of all named datasources
+func newDatasourceHandlers(credentials *prometheusCredentials, source string, eventData *keptnv2.GetSLITriggeredEventData, log keptncommon.LoggerInterface) (datasourceHandlers, error) {
+	handlers := datasourceHandlers{}
+	handler, err := newPrometheusHandler(credentials, source, eventData, log)
+	if err != nil {
+		return nil, err
+	}
+	handlers[defaultDatasource] = handler
+
+	for name, datasourceCredentials := range credentials.Datasources {
+		if datasourceCredentials == nil {
+			continue
+		}
+		if name == defaultDatasource {
+			return nil, errors.New("invalid datasource in " + source + ": the name " + defaultDatasource + " is reserved for the prometheus instance of the secret")
+		}
+		handler, err := newPrometheusHandler(datasourceCredentials, "datasource "+name+" of "+source, eventData, log)
+		if err != nil {
+			return nil, err
+		}
+		handlers[name] = handler
+	}
+	return handlers, nil
+}
+
+func newPrometheusHandler(credentials *prometheusCredentials, source string, eventData *keptnv2.GetSLITriggeredEventData, log keptncommon.LoggerInterface) (*prometheus.Handler, error) {
+	httpClient, err := prometheus.NewHTTPClient(credentials.TLS, &prometheus.ProxyConfig{URL: credentials.ProxyURL, NoProxy: credentials.NoProxy})
+	if err != nil {
+		log.Error("Could not configure connection to prometheus instance: " + err.Error())
+		return nil, errors.New("invalid connection settings found for " + source + ": " + err.Error())
+	}
+
+	prometheusURLs := credentials.getURLs()
+	prometheusHandler := prometheus.NewPrometheusHandler(prometheusURLs[0], eventData.Project, eventData.Stage, eventData.Service, eventData.GetSLI.CustomFilters)
+	prometheusHandler.HTTPClient = httpClient
+	prometheusHandler.Username = credentials.User
+	prometheusHandler.Password = credentials.Password
+	prometheusHandler.BearerToken = credentials.BearerToken
+	prometheusHandler.BearerTokenFile = credentials.BearerTokenFile
+	prometheusHandler.OAuth2 = credentials.OAuth2
+	prometheusHandler.SigV4 = credentials.SigV4
+	prometheusHandler.Headers = credentials.Headers
+	prometheusHandler.TenantID = credentials.TenantID
+	prometheusHandler.EmptyResultPolicy = emptyResultPolicy
+	prometheusHandler.QueryTimeout = config.QueryTimeout
+	prometheusHandler.MaxAttempts = config.QueryMaxAttempts
+	prometheusHandler.RetryBackoff = config.QueryRetryBackoff
+	prometheusHandler.ReplicaURLs = prometheusURLs[1:]
+	prometheusHandler.HealthCheckPath = credentials.HealthCheckPath
+	prometheusHandler.QueryParameters = credentials.QueryParameters
+	return prometheusHandler, nil
+}
+
+// getHandler returns the handler of a datasource, or of the default datasource if the name is empty
+func (h datasourceHandlers) getHandler(datasource string) (*prometheus.Handler, error) {
+	if datasource == "" {
+		datasource = defaultDatasource
+	}
+	handler, ok := h[datasource]
+	if !ok {
+		datasources := []string{}
+		for name := range h {
+			if name != defaultDatasource {
+				datasources = append(datasources, name)
+			}
+		}
+		if len(datasources) == 0 {
+			return nil, errors.New("unknown datasource " + datasource + " (no datasources defined)")
+		}
+		sort.Strings(datasources)
+		return nil, errors.New("unknown datasource " + datasource + " (available datasources: " + strings.Join(datasources, ", ") + ")")
+	}
+	return handler, nil
+}
+
+// getSLIResults retrieves the indicators with at most maxConcurrentQueries queries at the same time. The results are
+// returned in the order of the indicators
+func getSLIResults(ctx context.Context, handlers datasourceHandlers, indicators []string, start string, end string, maxConcurrentQueries int, log keptncommon.LoggerInterface) []*keptnv2.SLIResult {
+	if maxConcurrentQueries < 1 {
+		maxConcurrentQueries = 1
 	}
 
+	indicatorResults := make([][]*keptnv2.SLIResult, len(indicators))
+	semaphore := make(chan struct{}, maxConcurrentQueries)
+	var wg sync.WaitGroup
+	for i, indicator := range indicators {
+		wg.Add(1)
+		go func(i int, indicator string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			log.Info("Fetching indicator: " + indicator)
+			indicatorResults[i] = getIndicatorResults(ctx, handlers, indicator, start, end, maxConcurrentQueries, log)
+		}(i, indicator)
+	}
+	wg.Wait()
+
 	var sliResults []*keptnv2.SLIResult
+	for _, results := range indicatorResults {
+		sliResults = append(sliResults, results...)
+	}
+	return sliResults
+}
+
+func getIndicatorResults(ctx context.Context, handlers datasourceHandlers, indicator string, start string, end string, maxConcurrentQueries int, log keptncommon.LoggerInterface) []*keptnv2.SLIResult {
+	sliResults, err := getDatasourceResults(ctx, handlers, indicator, start, end, maxConcurrentQueries, log)
+	if err != nil {
+		return []*keptnv2.SLIResult{{
+			Metric:  indicator,
+			Value:   0,
+			Success: false,
+			Message: err.Error(),
+		}}
+	}
+	for _, sliResult := range sliResults {
+		if math.IsNaN(sliResult.Value) {
+			sliResult.Value = 0
+			sliResult.Success = false
+			sliResult.Message = "SLI value is NaN"
+		}
+	}
+	return sliResults
+}
+
+// getDatasourceResults queries an indicator from the datasources selected with its DATASOURCE option
+func getDatasourceResults(ctx context.Context, handlers datasourceHandlers, indicator string, start string, end string, maxConcurrentQueries int, log keptncommon.LoggerInterface) ([]*keptnv2.SLIResult, error) {
+	datasources, merge, err := handlers[defaultDatasource].GetDatasources(indicator)
+	if err != nil {
+		return nil, err
+	}
+	if len(datasources) > 1 {
+		return getMergedResults(ctx, handlers, datasources, merge, indicator, start, end, maxConcurrentQueries, log)
+	}
+
+	datasource := defaultDatasource
+	if len(datasources) == 1 {
+		datasource = datasources[0]
+	}
+	handler, err := handlers.getHandler(datasource)
+	if err != nil {
+		return nil, err
+	}
+	return handler.GetSLIResults(ctx, indicator, start, end, log)
+}
 
-	for _, indicator := range eventData.GetSLI.Indicators {
-		log.Info("Fetching indicator: " + indicator)
-		sliValue, err := prometheusHandler.GetSLIValue(indicator, eventData.GetSLI.Start, eventData.GetSLI.End, log)
+// getMergedResults queries an indicator from the datasources, with at most maxConcurrentQueries queries at the same
+// time, and merges the values into one SLI result, whose message lists the value of each datasource. The indicator fails
+// if any of the datasources fails or returns no value
+func getMergedResults(ctx context.Context, handlers datasourceHandlers, datasources []string, merge string, indicator string, start string, end string, maxConcurrentQueries int, log keptncommon.LoggerInterface) ([]*keptnv2.SLIResult, error) {
+	mergedHandlers := make([]*prometheus.Handler, len(datasources))
+	for i, datasource := range datasources {
+		handler, err := handlers.getHandler(datasource)
 		if err != nil {
-			sliResults = append(sliResults, &keptnv2.SLIResult{
-				Metric:  indicator,
-				Value:   0,
-				Success: false,
-				Message: err.Error(),
-			})
-		} else if math.IsNaN(sliValue) {
-			sliResults = append(sliResults, &keptnv2.SLIResult{
-				Metric:  indicator,
-				Value:   0,
-				Success: false,
-				Message: "SLI value is NaN",
-			})
-		} else {
-			sliResults = append(sliResults, &keptnv2.SLIResult{
-				Metric:  indicator,
-				Value:   sliValue,
-				Success: true,
-			})
+			return nil, err
 		}
+		mergedHandlers[i] = handler
 	}
-	return sliResults, nil
+	if maxConcurrentQueries < 1 {
+		maxConcurrentQueries = 1
+	}
+
+	datasourceResults := make([][]*keptnv2.SLIResult, len(datasources))
+	datasourceErrors := make([]error, len(datasources))
+	semaphore := make(chan struct{}, maxConcurrentQueries)
+	var wg sync.WaitGroup
+	for i, datasource := range datasources {
+		wg.Add(1)
+		go func(i int, datasource string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			log.Info("Fetching indicator " + indicator + " from datasource " + datasource)
+			datasourceResults[i], datasourceErrors[i] = mergedHandlers[i].GetSLIResults(ctx, indicator, start, end, log)
+		}(i, datasource)
+	}
+	wg.Wait()
+
+	values := []float64{}
+	datasourceValues := []string{}
+	datasourcesWithoutValue := []string{}
+	for i, datasource := range datasources {
+		if datasourceErrors[i] != nil {
+			return nil, errors.New("datasource " + datasource + ": " + datasourceErrors[i].Error())
+		}
+
+		// several datasources can not be combined with BREAKDOWN, so there is exactly one result
+		result := datasourceResults[i][0]
+		values = append(values, result.Value)
+		if math.IsNaN(result.Value) {
+			datasourcesWithoutValue = append(datasourcesWithoutValue, datasource)
+		}
+		datasourceValue := datasource + "=" + strconv.FormatFloat(result.Value, 'g', -1, 64)
+		if result.Message != "" {
+			datasourceValue += " (" + result.Message + ")"
+		}
+		datasourceValues = append(datasourceValues, datasourceValue)
+	}
+	message := merge + " of " + strings.Join(datasourceValues, ", ")
+	if len(datasourcesWithoutValue) > 0 {
+		return nil, errors.New("no value from datasource " + strings.Join(datasourcesWithoutValue, ", ") + ": " + message)
+	}
+
+	value, err := prometheus.MergeValues(values, merge)
+	if err != nil {
+		return nil, err
+	}
+	return []*keptnv2.SLIResult{{
+		Metric:  indicator,
+		Value:   value,
+		Success: true,
+		Message: message,
+	}}, nil
 }
 
 func getCustomQueries(keptnHandler *keptnv2.Keptn, project string, stage string, service string, logger keptncommon.LoggerInterface) (map[string]string, error) {
@@ -199,51 +479,117 @@ func getCustomQueries(keptnHandler *keptnv2.Keptn, project string, stage string,
 	return customQueries, nil
 }
 
-func getPrometheusApiURL(project string, kubeClient v1.CoreV1Interface, logger keptncommon.LoggerInterface) (string, error) {
-	logger.Info("Checking if external prometheus instance has been defined for project " + project)
-	secret, err := kubeClient.Secrets(namespace).Get("prometheus-credentials-"+project, metav1.GetOptions{})
-
-	// return cluster-internal prometheus URL if no secret has been found
+// getCredentialsSecretName renders the template of the name of the credentials secret, e.g. prometheus-credentials-{{ .Project }}
+func getCredentialsSecretName(nameTemplate string, project string, stage string, service string) (string, error) {
+	tmpl, err := template.New("secret").Option("missingkey=error").Parse(nameTemplate)
 	if err != nil {
-		logger.Info("could not retrieve or read secret: " + err.Error())
-		logger.Info("No external prometheus instance defined for project " + project + ". Using default: http://prometheus-service.monitoring.svc.cluster.local:8080")
-		return "http://prometheus-service.monitoring.svc.cluster.local:8080", nil
+		return "", errors.New("invalid credentials secret name template: " + err.Error())
 	}
+	secretName := &bytes.Buffer{}
+	if err := tmpl.Execute(secretName, secretNameData{Project: project, Stage: stage, Service: service}); err != nil {
+		return "", errors.New("could not render credentials secret name: " + err.Error())
+	}
+	return strings.TrimSpace(secretName.String()), nil
+}
 
-	pc := &prometheusCredentials{}
-	err = yaml.Unmarshal(secret.Data["prometheus-credentials"], pc)
+// getPrometheusCredentials returns the URL, credentials and TLS configuration of the external prometheus instance of a
+// service, and a description of where they have been found. The secrets of the service, the stage and the project are
+// looked up in this order. If none of them exists, the default URL is returned unless FAIL_ON_MISSING_SECRET is set
+func getPrometheusCredentials(project string, stage string, service string, secrets credentialsProvider, logger keptncommon.LoggerInterface) (*prometheusCredentials, string, error) {
+	levels := []struct {
+		name         string
+		nameTemplate string
+	}{
+		{name: "service", nameTemplate: config.ServiceCredentialsSecretName},
+		{name: "stage", nameTemplate: config.StageCredentialsSecretName},
+		{name: "project", nameTemplate: config.CredentialsSecretName},
+	}
 
-	if err != nil {
-		logger.Error("Could not parse credentials for external prometheus instance: " + err.Error())
-		return "", errors.New("invalid credentials format found in secret 'prometheus-credentials-" + project)
+	checkedSecrets := []string{}
+	for _, level := range levels {
+		if level.nameTemplate == "" {
+			continue
+		}
+		secretName, err := getCredentialsSecretName(level.nameTemplate, project, stage, service)
+		if err != nil {
+			return nil, "", err
+		}
+		checkedSecrets = append(checkedSecrets, secretName)
+
+		logger.Info("Checking if external prometheus instance has been defined for " + level.name + " in secret " + secretName)
+		data, err := secrets.GetCredentials(secretName)
+		if err != nil {
+			logger.Info("could not retrieve or read secret: " + err.Error())
+			continue
+		}
+
+		pc := &prometheusCredentials{}
+		if err := yaml.Unmarshal(data, pc); err != nil {
+			// the parser error is not logged, since it may quote parts of the secret
+			logger.Error("Could not parse credentials for external prometheus instance in secret " + secretName)
+			return nil, "", errors.New("invalid credentials format found in secret '" + secretName + "'")
+		}
+
+		pc, entry := pc.selectEntry(stage, service)
+		source := level.name + " secret '" + secretName + "' (" + entry + ")"
+		prometheusURLs := []string{}
+		for _, prometheusURL := range pc.getURLs() {
+			prometheusURLs = append(prometheusURLs, prometheus.RedactURLCredentials(prometheusURL))
+		}
+		logger.Info("Using external prometheus instance of " + source + " for service " + service + " in stage " + stage + " of project " + project + ": " + strings.Join(prometheusURLs, ", "))
+		return pc, source, nil
 	}
-	logger.Info("Using external prometheus instance for project " + project + ": " + pc.URL)
-	prometheusURL := generatePrometheusURL(pc)
 
-	return prometheusURL, nil
+	if config.FailOnMissingSecret {
+		return nil, "", errors.New("no external prometheus instance defined for service " + service + " in stage " + stage + " of project " + project + " (checked secrets " + strings.Join(checkedSecrets, ", ") + ")")
+	}
+	logger.Info("No external prometheus instance defined for project " + project + ". Using default: " + prometheus.RedactURLCredentials(config.DefaultPrometheusURL))
+	return &prometheusCredentials{URL: config.DefaultPrometheusURL}, "default prometheus instance", nil
 }
 
+// selectEntry returns the most specific entry of a secret for a service, and a description of the entry. An entry of
+// a stage or service replaces the settings of the secret completely
+func (pc *prometheusCredentials) selectEntry(stage string, service string) (*prometheusCredentials, string) {
+	if stageCredentials, ok := pc.Stages[stage]; ok && stageCredentials != nil {
+		if serviceCredentials, ok := stageCredentials.Services[service]; ok && serviceCredentials != nil {
+			return serviceCredentials, "entry of service " + service + " in stage " + stage
+		}
+		return stageCredentials, "entry of stage " + stage
+	}
+	if serviceCredentials, ok := pc.Services[service]; ok && serviceCredentials != nil {
+		return serviceCredentials, "entry of service " + service
+	}
+	return pc, "default entry"
+}
+
+// generatePrometheusURL returns the URL of the prometheus instance with https as default scheme. Credentials are not
+// embedded into the URL, since errors of net/http contain the URL and end up in SLI results and logs
 func generatePrometheusURL(pc *prometheusCredentials) string {
 	prometheusURL := pc.URL
 
-	credentialsString := ""
-
-	if pc.User != "" && pc.Password != "" {
-		credentialsString = url.QueryEscape(pc.User) + ":" + url.QueryEscape(pc.Password) + "@"
-	}
-	if strings.HasPrefix(prometheusURL, "https://") {
-		prometheusURL = strings.TrimPrefix(prometheusURL, "https://")
-		prometheusURL = "https://" + credentialsString + prometheusURL
-	} else if strings.HasPrefix(prometheusURL, "http://") {
-		prometheusURL = strings.TrimPrefix(prometheusURL, "http://")
-		prometheusURL = "http://" + credentialsString + prometheusURL
-	} else {
+	if !strings.HasPrefix(prometheusURL, "https://") && !strings.HasPrefix(prometheusURL, "http://") {
 		// assume https transport
-		prometheusURL = "https://" + credentialsString + prometheusURL
+		prometheusURL = "https://" + strings.TrimSpace(prometheusURL)
 	}
 	return strings.Replace(prometheusURL, " ", "", -1)
 }
 
+// getURLs returns URL and the replicas in URLs, the first URL is queried first
+func (pc *prometheusCredentials) getURLs() []string {
+	if len(pc.URLs) == 0 {
+		return []string{generatePrometheusURL(pc)}
+	}
+
+	prometheusURLs := []string{}
+	if pc.URL != "" {
+		prometheusURLs = append(prometheusURLs, generatePrometheusURL(pc))
+	}
+	for _, replicaURL := range pc.URLs {
+		prometheusURLs = append(prometheusURLs, generatePrometheusURL(&prometheusCredentials{URL: replicaURL}))
+	}
+	return prometheusURLs
+}
+
 func sendGetSLIStartedEvent(inputEvent cloudevents.Event, eventData *keptnv2.GetSLITriggeredEventData, keptnContext interface{}) error {
 
 	source, _ := url.Parse(serviceName)
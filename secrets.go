@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// secretCacheSyncTimeout is the time the initial listing of the secrets may take
+const secretCacheSyncTimeout = time.Minute
+
+// credentialsSecretSelector selects the credentials secrets. Only these secrets are listed and cached, not the other
+// secrets of the namespace, like Helm releases or API tokens
+const credentialsSecretSelector = "keptn.sh/prometheus-credentials=true"
+
+// secretCache keeps the credentials secrets of a namespace up to date with a watch, so neither an event nor a rotated
+// secret requires a request to the Kubernetes API. Secrets without the label are requested from the API on every lookup
+type secretCache struct {
+	lister     corelisters.SecretNamespaceLister
+	kubeClient kubernetes.Interface
+	namespace  string
+}
+
+// newSecretCache starts an informer for the credentials secrets of the namespace and waits until all of them have been
+// listed. The informer runs until stopCh is closed
+func newSecretCache(kubeClient kubernetes.Interface, namespace string, stopCh <-chan struct{}) (*secretCache, error) {
+	// an informer without namespace would cache the secrets of all namespaces
+	if namespace == "" {
+		return nil, errors.New("the namespace of the secrets is not set (POD_NAMESPACE)")
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 0, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = credentialsSecretSelector
+		}))
+	secretInformer := factory.Core().V1().Secrets()
+	lister := secretInformer.Lister()
+	factory.Start(stopCh)
+
+	syncStopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-stopCh:
+		case <-time.After(secretCacheSyncTimeout):
+		}
+		close(syncStopCh)
+	}()
+	if !cache.WaitForCacheSync(syncStopCh, secretInformer.Informer().HasSynced) {
+		return nil, errors.New("could not list the secrets of namespace " + namespace)
+	}
+	return &secretCache{lister: lister.Secrets(namespace), kubeClient: kubeClient, namespace: namespace}, nil
+}
+
+// GetCredentials returns the prometheus-credentials key of the secret. Secrets created before the label was required
+// are not in the cache and are requested from the API instead
+func (c *secretCache) GetCredentials(name string) ([]byte, error) {
+	secret, err := c.lister.Get(name)
+	if apierrors.IsNotFound(err) {
+		secret, err = c.kubeClient.CoreV1().Secrets(c.namespace).Get(name, metav1.GetOptions{})
+		if err == nil {
+			log.Printf("secret %s/%s is not labeled with %s and is requested on every evaluation, label it so that it is cached", c.namespace, name, credentialsSecretSelector)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return secret.Data[credentialsSecretKey], nil
+}
+
+// newKubernetesClient uses the in-cluster config of the pod, or the kubeconfig (KUBECONFIG or ~/.kube/config) when
+// running outside of a cluster. Without namespace, the namespace of the current kubeconfig context is used
+func newKubernetesClient(namespace string) (kubernetes.Interface, string, error) {
+	clusterConfig, err := rest.InClusterConfig()
+	if err == rest.ErrNotInCluster {
+		kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{})
+		clusterConfig, err = kubeConfig.ClientConfig()
+		if err != nil {
+			return nil, "", errors.New("not running in a cluster and no kubeconfig found: " + err.Error())
+		}
+		if namespace == "" {
+			if namespace, _, err = kubeConfig.Namespace(); err != nil {
+				return nil, "", err
+			}
+		}
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(clusterConfig)
+	if err != nil {
+		return nil, "", err
+	}
+	return kubeClient, namespace, nil
+}
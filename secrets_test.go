@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretCache(t *testing.T) {
+	labels := map[string]string{"keptn.sh/prometheus-credentials": "true"}
+	kubeClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "prometheus-credentials-sockshop", Namespace: "keptn", Labels: labels},
+		Data:       map[string][]byte{"prometheus-credentials": []byte("url: https://prometheus.example.com")},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "prometheus-credentials-other-namespace", Namespace: "default", Labels: labels},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "prometheus-credentials-unlabeled", Namespace: "keptn"},
+		Data:       map[string][]byte{"prometheus-credentials": []byte("url: https://unlabeled.example.com")},
+	})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	secrets, err := newSecretCache(kubeClient, "keptn", stopCh)
+	assert.Nil(t, err)
+
+	credentials, err := secrets.GetCredentials("prometheus-credentials-sockshop")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "url: https://prometheus.example.com", string(credentials))
+
+	_, err = secrets.GetCredentials("prometheus-credentials-other-namespace")
+	assert.True(t, errors.IsNotFound(err))
+
+	// secrets without the label are not cached, but still requested from the API
+	_, err = secrets.lister.Get("prometheus-credentials-unlabeled")
+	assert.True(t, errors.IsNotFound(err))
+	credentials, err = secrets.GetCredentials("prometheus-credentials-unlabeled")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "url: https://unlabeled.example.com", string(credentials))
+
+	_, err = secrets.GetCredentials("prometheus-credentials-missing")
+	assert.True(t, errors.IsNotFound(err))
+
+	// rotated and created secrets are picked up without querying the API
+	_, err = kubeClient.CoreV1().Secrets("keptn").Update(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "prometheus-credentials-sockshop", Namespace: "keptn", Labels: labels},
+		Data:       map[string][]byte{"prometheus-credentials": []byte("url: https://rotated.example.com")},
+	})
+	assert.Nil(t, err)
+	_, err = kubeClient.CoreV1().Secrets("keptn").Create(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "prometheus-credentials-podtato", Namespace: "keptn", Labels: labels},
+	})
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		credentials, err := secrets.GetCredentials("prometheus-credentials-sockshop")
+		if err != nil || string(credentials) != "url: https://rotated.example.com" {
+			return false
+		}
+		_, err = secrets.GetCredentials("prometheus-credentials-podtato")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// deleted secrets are removed from the cache
+	assert.Nil(t, kubeClient.CoreV1().Secrets("keptn").Delete("prometheus-credentials-podtato", &metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool {
+		_, err := secrets.GetCredentials("prometheus-credentials-podtato")
+		return errors.IsNotFound(err)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSecretCacheWithoutNamespace(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	_, err := newSecretCache(fake.NewSimpleClientset(), "", stopCh)
+	assert.EqualError(t, err, "the namespace of the secrets is not set (POD_NAMESPACE)")
+}
+
+func TestNewKubernetesClientWithKubeconfig(t *testing.T) {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		t.Skip("running in a cluster")
+	}
+
+	kubeconfig, err := ioutil.TempFile("", "kubeconfig")
+	assert.Nil(t, err)
+	defer os.Remove(kubeconfig.Name())
+	_, err = kubeconfig.WriteString(`apiVersion: v1
+kind: Config
+clusters:
+- name: local
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: local
+  context:
+    cluster: local
+    namespace: keptn-local
+current-context: local
+`)
+	assert.Nil(t, err)
+	assert.Nil(t, kubeconfig.Close())
+
+	previous, set := os.LookupEnv("KUBECONFIG")
+	os.Setenv("KUBECONFIG", kubeconfig.Name())
+	defer func() {
+		if set {
+			os.Setenv("KUBECONFIG", previous)
+		} else {
+			os.Unsetenv("KUBECONFIG")
+		}
+	}()
+
+	_, secretNamespace, err := newKubernetesClient("")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "keptn-local", secretNamespace)
+
+	_, secretNamespace, err = newKubernetesClient("keptn")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "keptn", secretNamespace)
+
+	os.Setenv("KUBECONFIG", filepath.Join(os.TempDir(), "missing-kubeconfig"))
+	_, _, err = newKubernetesClient("keptn")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/keptn-contrib/prometheus-sli-service/lib/prometheus"
+	keptncommon "github.com/keptn/go-utils/pkg/lib/keptn"
+	"gopkg.in/yaml.v2"
+)
+
+const validateCommand = "validate"
+
+// validateSLIFiles checks the queries of the given SLI configuration files offline and reports every invalid indicator,
+// e.g. prometheus-sli-service validate prometheus/sli.yaml
+func validateSLIFiles(files []string, out io.Writer) int {
+	if len(files) == 0 {
+		fmt.Fprintln(out, "usage: prometheus-sli-service "+validateCommand+" <sli.yaml>...")
+		return 2
+	}
+
+	exitCode := 0
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(out, "%s: could not read file: %s\n", file, err.Error())
+			exitCode = 1
+			continue
+		}
+
+		sliConfig := keptncommon.SLIConfig{}
+		if err := yaml.Unmarshal(content, &sliConfig); err != nil {
+			fmt.Fprintf(out, "%s: could not parse SLI configuration: %s\n", file, err.Error())
+			exitCode = 1
+			continue
+		}
+
+		errs := prometheus.ValidateCustomQueries(sliConfig.Indicators)
+		for _, err := range errs {
+			fmt.Fprintf(out, "%s: %s\n", file, err.Error())
+		}
+		if len(errs) > 0 {
+			exitCode = 1
+			continue
+		}
+		fmt.Fprintf(out, "%s: %d indicators OK\n", file, len(sliConfig.Indicators))
+	}
+	return exitCode
+}
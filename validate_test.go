@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateSLIFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sli")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	validFile := filepath.Join(dir, "valid.yaml")
+	invalidFile := filepath.Join(dir, "invalid.yaml")
+	ioutil.WriteFile(validFile, []byte(`---
+spec_version: '1.0'
+indicators:
+  cpu_usage: avg(rate(container_cpu_usage_seconds_total{namespace="$PROJECT-$STAGE",pod_name=~"$SERVICE-primary-.*"}[5m]))
+`), 0644)
+	ioutil.WriteFile(invalidFile, []byte(`---
+spec_version: '1.0'
+indicators:
+  cpu_usage: avg(rate(container_cpu_usage_seconds_total{namespace="$PROJECT-$STAGE"}[5m])
+`), 0644)
+
+	out := &bytes.Buffer{}
+	assert.EqualValues(t, 0, validateSLIFiles([]string{validFile}, out))
+	assert.EqualValues(t, validFile+": 1 indicators OK\n", out.String())
+
+	out.Reset()
+	assert.EqualValues(t, 1, validateSLIFiles([]string{validFile, invalidFile}, out))
+	assert.Contains(t, out.String(), invalidFile+": cpu_usage: invalid PromQL query: line 1, column 4: unclosed left parenthesis")
+}
+
+func TestValidateSLIFilesWithoutFiles(t *testing.T) {
+	out := &bytes.Buffer{}
+	assert.EqualValues(t, 2, validateSLIFiles([]string{}, out))
+}